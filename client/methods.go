@@ -37,3 +37,45 @@ func (wp ScannedWaypoint) HasMarket() bool {
 	}
 	return false
 }
+
+func (wp ScannedWaypoint) HasShipyard() bool {
+	for _, trait := range wp.Traits {
+		if trait.Symbol == WaypointTraitSymbolSHIPYARD {
+			return true
+		}
+	}
+	return false
+}
+
+// miningStrength sums the strength of all mining lasers in the mounts.
+func miningStrength(mounts []ShipMount) int {
+	strength := 0
+	for _, mount := range mounts {
+		switch mount.Symbol {
+		case MOUNTMININGLASERI, MOUNTMININGLASERII, MOUNTMININGLASERIII:
+			if mount.Strength != nil {
+				strength += *mount.Strength
+			}
+		}
+	}
+	return strength
+}
+
+func (ship Ship) MiningStrength() int {
+	return miningStrength(ship.Mounts)
+}
+
+func (ship ShipyardShip) MiningStrength() int {
+	return miningStrength(ship.Mounts)
+}
+
+// CanSurvey reports whether the ship has a surveyor mounted.
+func (ship Ship) CanSurvey() bool {
+	for _, mount := range ship.Mounts {
+		switch mount.Symbol {
+		case MOUNTSURVEYORI, MOUNTSURVEYORII, MOUNTSURVEYORIII:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+	"golang.org/x/time/rate"
+)
+
+const (
+	DEFAULT_AGENT = "default"
+	// DEFAULT_RATE and DEFAULT_BURST are the requests per second the API allows
+	DEFAULT_RATE  = 2
+	DEFAULT_BURST = 7
+)
+
+// Account is everything one agent needs to play: its API client and token,
+// where its state and ledger are kept and where its credentials are stored.
+type Account struct {
+	Name      string
+	Token     string
+	Client    client.ClientWithResponsesInterface
+	StatePath string
+	Creds     Credentials
+	Ledger    *Ledger
+	Limiter   *rate.Limiter
+	// Strategy steers the agent's fleet, it is shared by all agents
+	Strategy *Strategy
+	// Watcher reloads the strategy from the config file, if there is one
+	Watcher *ConfigWatcher
+	// Events is where the agent's ships and game publish what happens
+	Events *EventBus
+}
+
+// AgentConfig describes one agent in the agents file.
+type AgentConfig struct {
+	Name string `json:"name"`
+	// ConfigDir is where the token and registration are stored
+	ConfigDir string `json:"config_dir"`
+	StateFile string `json:"state_file"`
+	// Rate and Burst configure the agent's own rate limiter
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// LoadAgentConfigs reads a list of agents from a JSON file. Defaults are filled
+// in by Config.AgentConfigs.
+func LoadAgentConfigs(path string) ([]AgentConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	configs := []AgentConfig{}
+	if err := json.Unmarshal(b, &configs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("%s: no agents configured", path)
+	}
+	return configs, nil
+}
+
+// NewAccount creates the account of a configured agent. The client is set up
+// separately with Connect.
+func NewAccount(config AgentConfig, strategy *Strategy) *Account {
+	account := &Account{
+		Name:      config.Name,
+		StatePath: config.StateFile,
+		Creds:     Credentials{Dir: config.ConfigDir},
+		Ledger:    &Ledger{},
+		Limiter:   rate.NewLimiter(rate.Limit(config.Rate), config.Burst),
+		Strategy:  strategy,
+		Events:    NewEventBus(),
+	}
+	account.Events.Subscribe(account.Ledger.Handle)
+	return account
+}
+
+// CurrentStrategy returns the latest strategy, which may not be applied to the
+// ships yet. Unlike Strategy it is safe to use from other goroutines.
+func (account *Account) CurrentStrategy() *Strategy {
+	if account.Watcher == nil {
+		return account.Strategy
+	}
+	return account.Watcher.Strategy()
+}
+
+// ModeStatePath returns the state file for a simulated or replayed session, so
+// it does not overwrite the real state: game.sim.state.json for game.state.json.
+func ModeStatePath(path string, mode string) string {
+	return strings.TrimSuffix(path, ".state.json") + "." + mode + ".state.json"
+}
+
+func (account *Account) AddBearer(ctx context.Context, req *http.Request) error {
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", account.Token))
+	return nil
+}
+
+// Connect creates the API client that sends requests through doer with the account's token.
+func (account *Account) Connect(url string, doer client.HttpRequestDoer) error {
+	c, err := client.NewClientWithResponses(url, client.WithRequestEditorFn(account.AddBearer), client.WithHTTPClient(doer))
+	if err != nil {
+		return err
+	}
+	account.Client = c
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+// apiResponse is a response of the API with data as its data, or for a status
+// of 400 and above with data as the message of an error. Fake clients hand it
+// to the generated Parse functions, so the responses are parsed as the real
+// ones are.
+func apiResponse(status int, data any) *http.Response {
+	var body []byte
+	if status >= 400 {
+		body, _ = json.Marshal(map[string]any{"error": map[string]any{"code": status, "message": data}})
+	} else {
+		body, _ = json.Marshal(map[string]any{"data": data})
+	}
+	return &http.Response{StatusCode: status, Header: http.Header{"Content-Type": {"application/json"}}, Body: io.NopCloser(bytes.NewReader(body))}
+}
+
+// cargoWith returns a hold of 30 units holding the units of a good.
+func cargoWith(good string, units int) client.ShipCargo {
+	cargo := client.ShipCargo{Capacity: 30, Units: units, Inventory: []client.ShipCargoItem{}}
+	if units > 0 {
+		cargo.Inventory = append(cargo.Inventory, client.ShipCargoItem{Symbol: good, Units: units})
+	}
+	return cargo
+}
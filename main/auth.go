@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+const (
+	CONFIG_DIR_NAME    = "spacetraders-go"
+	TOKEN_FILE_NAME    = "token"
+	AGENT_FILE_NAME    = "agent.json"
+	RESET_STATE_SUFFIX = ".before-reset"
+)
+
+// ErrTokenRejected means the server does not know our token, which happens when
+// the game is reset and all agents are wiped.
+var ErrTokenRejected = errors.New("token rejected by the server")
+
+// Registration is what the agent was registered with, so it can be registered
+// again after a server reset.
+type Registration struct {
+	Symbol  string `json:"symbol"`
+	Faction string `json:"faction"`
+}
+
+// Credentials keeps the token and the registration in a config directory.
+type Credentials struct {
+	Dir string
+}
+
+// DefaultConfigDir returns the user's config directory for this program, or the
+// working directory if there is none.
+func DefaultConfigDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(dir, CONFIG_DIR_NAME)
+}
+
+// LoadToken reads the token from the config directory, falling back to the
+// token file in the working directory that older versions used.
+func (creds Credentials) LoadToken() (string, error) {
+	path := filepath.Join(creds.Dir, TOKEN_FILE_NAME)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		path = TOKEN_FILE
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return token, nil
+}
+
+func (creds Credentials) SaveToken(token string) error {
+	if err := os.MkdirAll(creds.Dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(creds.Dir, TOKEN_FILE_NAME), []byte(token+"\n"), 0600)
+}
+
+func (creds Credentials) LoadRegistration() (Registration, error) {
+	reg := Registration{}
+	b, err := os.ReadFile(filepath.Join(creds.Dir, AGENT_FILE_NAME))
+	if err != nil {
+		return reg, err
+	}
+	err = json.Unmarshal(b, &reg)
+	return reg, err
+}
+
+func (creds Credentials) SaveRegistration(reg Registration) error {
+	if err := os.MkdirAll(creds.Dir, 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(creds.Dir, AGENT_FILE_NAME), b, 0600)
+}
+
+// RegisterAgent registers a new agent for the account, starts using its token
+// and stores the token and the registration.
+func RegisterAgent(account *Account, reg Registration) (client.Agent, error) {
+	resp, err := account.Client.RegisterWithResponse(context.TODO(), client.RegisterJSONRequestBody{
+		Faction: reg.Faction,
+		Symbol:  reg.Symbol,
+	})
+	if err != nil {
+		return client.Agent{}, err
+	}
+	if resp.StatusCode() != 201 {
+		return client.Agent{}, ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	data := resp.JSON201.Data
+	account.Token = data.Token
+	if err := account.Creds.SaveToken(data.Token); err != nil {
+		return data.Agent, err
+	}
+	if err := account.Creds.SaveRegistration(reg); err != nil {
+		return data.Agent, err
+	}
+	slog.Info("Registered agent", "agent", account.Name, "symbol", data.Agent.Symbol, "faction", reg.Faction, "headquarters", data.Agent.Headquarters, "config_dir", account.Creds.Dir)
+	return data.Agent, nil
+}
+
+// ValidateToken fetches our agent, returning ErrTokenRejected if the server does
+// not accept the token.
+func ValidateToken(account *Account) (client.Agent, error) {
+	resp, err := account.Client.GetMyAgentWithResponse(context.TODO())
+	if err != nil {
+		return client.Agent{}, err
+	}
+	if resp.StatusCode() == http.StatusUnauthorized {
+		return client.Agent{}, fmt.Errorf("%w: %v", ErrTokenRejected, ParseAPIError(resp.StatusCode(), resp.Body))
+	}
+	if resp.StatusCode() != 200 {
+		return client.Agent{}, ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	return resp.JSON200.Data, nil
+}
+
+// CheckToken makes sure the token works before we start. When the server was
+// reset it registers again with the stored registration if reregister is set,
+// and moves the state of the old universe out of the way.
+func CheckToken(account *Account, reregister bool) error {
+	agent, err := ValidateToken(account)
+	if err == nil {
+		slog.Info("Token accepted", "agent", account.Name, "symbol", agent.Symbol, "credits", agent.Credits)
+		return nil
+	}
+	if !errors.Is(err, ErrTokenRejected) {
+		return err
+	}
+	if !reregister {
+		return fmt.Errorf("%w, the server was probably reset: use the register command or run with -auto-register", err)
+	}
+	reg, rerr := account.Creds.LoadRegistration()
+	if rerr != nil {
+		return fmt.Errorf("%w and there is no registration to register again with: %v", err, rerr)
+	}
+	slog.Warn("Token rejected, the server was probably reset; registering again", "agent", account.Name, "symbol", reg.Symbol, "faction", reg.Faction)
+	if _, err := RegisterAgent(account, reg); err != nil {
+		return err
+	}
+	if err := os.Rename(account.StatePath, account.StatePath+RESET_STATE_SUFFIX); err == nil {
+		slog.Warn("Moved the state of the old universe aside", "agent", account.Name, "path", account.StatePath+RESET_STATE_SUFFIX)
+	}
+	return nil
+}
+
+func registerCommand(account *Account, args []string) (Result, error) {
+	agent, err := RegisterAgent(account, Registration{Faction: strings.ToUpper(args[0]), Symbol: strings.ToUpper(args[1])})
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Data:   agent,
+		Header: []string{"AGENT", "HEADQUARTERS", "CREDITS", "TOKEN"},
+		Rows:   [][]string{{agent.Symbol, agent.Headquarters, fmt.Sprint(agent.Credits), filepath.Join(account.Creds.Dir, TOKEN_FILE_NAME)}},
+	}, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+const REDACTED = "REDACTED"
+
+// tokenField matches the token the API hands out when registering an agent
+var tokenField = regexp.MustCompile(`"token"\s*:\s*"[^"]*"`)
+
+// Interaction is a single request and its response as stored in a cassette. The
+// URI is relative to the server URL, so a cassette recorded against one server
+// can be replayed against another.
+type Interaction struct {
+	Time            time.Time   `json:"time"`
+	Method          string      `json:"method"`
+	URI             string      `json:"uri"`
+	RequestHeaders  http.Header `json:"requestHeaders"`
+	RequestBody     string      `json:"requestBody,omitempty"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"responseHeaders"`
+	ResponseBody    string      `json:"responseBody"`
+}
+
+func (i Interaction) key() string {
+	return i.Method + " " + i.URI
+}
+
+// Recorder is an HttpRequestDoer that passes requests on to the next doer and
+// appends every request/response pair to a cassette file, one JSON object per
+// line, with the bearer token redacted.
+type Recorder struct {
+	next   client.HttpRequestDoer
+	server string
+	mu     sync.Mutex
+	file   *os.File
+	enc    *json.Encoder
+}
+
+func NewRecorder(next client.HttpRequestDoer, path string, server string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{next: next, server: server, file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (r *Recorder) Do(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		reqBody = b
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+	resp, err := r.next.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	headers := req.Header.Clone()
+	if headers.Get("Authorization") != "" {
+		headers.Set("Authorization", "Bearer "+REDACTED)
+	}
+	interaction := Interaction{
+		Time:            time.Now(),
+		Method:          req.Method,
+		URI:             relativeURI(req, r.server),
+		RequestHeaders:  headers,
+		RequestBody:     redact(string(reqBody), token),
+		Status:          resp.StatusCode,
+		ResponseHeaders: resp.Header.Clone(),
+		ResponseBody:    redact(string(respBody), token),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(interaction); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// relativeURI returns the URI of the request without the server URL.
+func relativeURI(req *http.Request, server string) string {
+	uri := strings.TrimPrefix(req.URL.String(), strings.TrimSuffix(server, "/"))
+	if !strings.HasPrefix(uri, "/") {
+		uri = "/" + uri
+	}
+	return uri
+}
+
+func redact(body string, token string) string {
+	if token != "" {
+		body = strings.ReplaceAll(body, token, REDACTED)
+	}
+	return tokenField.ReplaceAllString(body, `"token":"`+REDACTED+`"`)
+}
+
+// Replayer is an HttpRequestDoer that answers requests from a cassette without
+// touching the network. Recorded responses for the same method and URI are
+// returned in the order they were recorded.
+type Replayer struct {
+	server       string
+	mu           sync.Mutex
+	interactions map[string][]Interaction
+}
+
+func NewReplayer(path string, server string) (*Replayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := &Replayer{server: server, interactions: make(map[string][]Interaction)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		interaction := Interaction{}
+		if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			return nil, err
+		}
+		r.interactions[interaction.key()] = append(r.interactions[interaction.key()], interaction)
+	}
+	return r, scanner.Err()
+}
+
+func (r *Replayer) Do(req *http.Request) (*http.Response, error) {
+	key := req.Method + " " + relativeURI(req, r.server)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	queue := r.interactions[key]
+	if len(queue) == 0 {
+		return nil, fmt.Errorf("no recorded response left for %s", key)
+	}
+	interaction := queue[0]
+	r.interactions[key] = queue[1:]
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+		StatusCode:    interaction.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        interaction.ResponseHeaders,
+		Body:          io.NopCloser(strings.NewReader(interaction.ResponseBody)),
+		ContentLength: int64(len(interaction.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+// Remaining returns how many recorded interactions have not been replayed yet.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	remaining := 0
+	for _, queue := range r.interactions {
+		remaining += len(queue)
+	}
+	return remaining
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+	"github.com/Dutchy-/spacetrader-go/main/sim"
+)
+
+// session registers an agent and fetches it, as the bot does when it starts.
+func session(t *testing.T, account *Account) client.Agent {
+	register, err := account.Client.RegisterWithResponse(context.TODO(), client.RegisterJSONRequestBody{Faction: "COSMIC", Symbol: "TEST"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if register.StatusCode() != 201 {
+		t.Fatalf("register: status %d: %s", register.StatusCode(), register.Body)
+	}
+	account.Token = register.JSON201.Data.Token
+	agent, err := account.Client.GetMyAgentWithResponse(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agent.StatusCode() != 200 {
+		t.Fatalf("agent: status %d: %s", agent.StatusCode(), agent.Body)
+	}
+	return agent.JSON200.Data
+}
+
+func TestCassetteRecordAndReplay(t *testing.T) {
+	const token = "secret-token"
+	path := filepath.Join(t.TempDir(), "session.cassette")
+	server := httptest.NewServer(sim.New(sim.Options{Token: token}))
+
+	recorder, err := NewRecorder(server.Client(), path, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := NewAccount(AgentConfig{Name: "test", Rate: 1000, Burst: 100}, &Strategy{})
+	if err := account.Connect(server.URL, recorder); err != nil {
+		t.Fatal(err)
+	}
+	recorded := session(t, account)
+	if account.Token != token {
+		t.Fatalf("registered with token %q, want %q", account.Token, token)
+	}
+	recorder.Close()
+	server.Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cassette := string(b)
+	if strings.Contains(cassette, token) {
+		t.Errorf("cassette contains the token:\n%s", cassette)
+	}
+	if !strings.Contains(cassette, `"Authorization":["Bearer `+REDACTED+`"]`) {
+		t.Errorf("cassette does not contain a redacted Authorization header:\n%s", cassette)
+	}
+	if !strings.Contains(cassette, `\"token\":\"`+REDACTED+`\"`) {
+		t.Errorf("cassette does not contain a redacted token field:\n%s", cassette)
+	}
+
+	// the server is gone, so everything has to come from the cassette
+	replayer, err := NewReplayer(path, "http://replay.invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = NewAccount(AgentConfig{Name: "test", Rate: 1000, Burst: 100}, &Strategy{})
+	if err := account.Connect("http://replay.invalid", replayer); err != nil {
+		t.Fatal(err)
+	}
+	replayed := session(t, account)
+	if replayed != recorded {
+		t.Errorf("replayed agent %+v, recorded %+v", replayed, recorded)
+	}
+	if account.Token != REDACTED {
+		t.Errorf("replayed token %q, want %q", account.Token, REDACTED)
+	}
+	if remaining := replayer.Remaining(); remaining != 0 {
+		t.Errorf("%d recorded interactions were not replayed", remaining)
+	}
+}
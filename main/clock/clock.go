@@ -0,0 +1,51 @@
+// Package clock is the source of time for the game and the simulator, so
+// cooldowns, surveys and scheduling can be tested without waiting.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for everything time-dependent.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// Real is the wall clock.
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+func (Real) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// Fake only moves when it is advanced. Sleeping advances it instantly.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Fake) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+func (c *Fake) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
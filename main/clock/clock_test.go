@@ -0,0 +1,22 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	if !c.Now().Equal(start) {
+		t.Fatalf("Now() = %v, want %v", c.Now(), start)
+	}
+	c.Advance(time.Minute)
+	if want := start.Add(time.Minute); !c.Now().Equal(want) {
+		t.Errorf("after Advance Now() = %v, want %v", c.Now(), want)
+	}
+	c.Sleep(time.Hour)
+	if want := start.Add(time.Hour + time.Minute); !c.Now().Equal(want) {
+		t.Errorf("after Sleep Now() = %v, want %v", c.Now(), want)
+	}
+}
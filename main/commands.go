@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+	"github.com/Dutchy-/spacetrader-go/main/clock"
+)
+
+// Result is the outcome of a command, as raw data for JSON output and as a table.
+type Result struct {
+	Data   interface{}
+	Header []string
+	Rows   [][]string
+}
+
+type Command struct {
+	Usage       string
+	Description string
+	Args        int
+	Run         func(account *Account, args []string) (Result, error)
+}
+
+var Commands = map[string]Command{
+	"ships":     {"ships", "list all ships", 0, shipsCommand},
+	"ship":      {"ship <ship>", "show a single ship", 1, shipCommand},
+	"nav":       {"nav <ship> <waypoint>", "navigate a ship to a waypoint", 2, navCommand},
+	"dock":      {"dock <ship>", "dock a ship", 1, dockCommand},
+	"orbit":     {"orbit <ship>", "move a ship into orbit", 1, orbitCommand},
+	"survey":    {"survey <ship>", "survey the waypoint the ship is at", 1, surveyCommand},
+	"extract":   {"extract <ship>", "extract resources at the waypoint the ship is at", 1, extractCommand},
+	"sell":      {"sell <ship> <good> <units>", "sell cargo at the market the ship is docked at", 3, sellCommand},
+	"buy":       {"buy <ship> <good> <units>", "buy cargo at the market the ship is docked at", 3, buyCommand},
+	"refuel":    {"refuel <ship>", "refuel a docked ship", 1, refuelCommand},
+	"contracts": {"contracts", "list all contracts", 0, contractsCommand},
+	"accept":    {"accept <contract>", "accept a contract", 1, acceptCommand},
+	"market":    {"market <waypoint>", "show the market at a waypoint", 1, marketCommand},
+	"register":  {"register <faction> <symbol>", "register a new agent and store its token", 2, registerCommand},
+}
+
+// CommandUsage writes the list of commands.
+func CommandUsage(w io.Writer) {
+	names := sortedKeys(Commands)
+	fmt.Fprintln(w, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-28s %s\n", Commands[name].Usage, Commands[name].Description)
+	}
+}
+
+// RunCommand runs the command in args and writes its result to w as a table or as JSON.
+func RunCommand(account *Account, args []string, w io.Writer, asJSON bool) (err error) {
+	cmd, ok := Commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	if len(args)-1 != cmd.Args {
+		return fmt.Errorf("usage: %s", cmd.Usage)
+	}
+	// The ship methods panic on API errors, which is fine for the game loop but not here
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s failed: %v", args[0], r)
+		}
+	}()
+	result, err := cmd.Run(account, args[1:])
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result.Data)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(result.Header, "\t"))
+	for _, row := range result.Rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// SystemSymbol returns the system a waypoint is in, X1-DF55 for X1-DF55-20250Z.
+func SystemSymbol(waypoint string) string {
+	parts := strings.Split(waypoint, "-")
+	if len(parts) < 3 {
+		return waypoint
+	}
+	return strings.Join(parts[:2], "-")
+}
+
+func loadShip(account *Account, symbol string) (*Miner, error) {
+	resp, err := account.Client.GetMyShipWithResponse(context.TODO(), symbol)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != 200 {
+		return nil, ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	return &Miner{Ship: Ship{Ship: resp.JSON200.Data, Clock: clock.Real{}, Account: account}}, nil
+}
+
+func parseUnits(units string) (int, error) {
+	n, err := strconv.Atoi(units)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid number of units %q", units)
+	}
+	return n, nil
+}
+
+func until(t time.Time) string {
+	if t.Before(time.Now()) {
+		return ""
+	}
+	return time.Until(t).Round(time.Second).String()
+}
+
+func cargoSummary(cargo client.ShipCargo) string {
+	items := []string{}
+	for _, item := range cargo.Inventory {
+		items = append(items, fmt.Sprintf("%d %s", item.Units, item.Symbol))
+	}
+	return strings.Join(items, ", ")
+}
+
+func shipRow(ship client.Ship) []string {
+	return []string{
+		ship.Symbol,
+		string(ship.Registration.Role),
+		string(ship.Nav.Status),
+		ship.Nav.WaypointSymbol,
+		fmt.Sprintf("%d/%d", ship.Cargo.Units, ship.Cargo.Capacity),
+		fmt.Sprintf("%d/%d", ship.Fuel.Current, ship.Fuel.Capacity),
+	}
+}
+
+var shipHeader = []string{"SHIP", "ROLE", "STATUS", "WAYPOINT", "CARGO", "FUEL"}
+
+func navResult(ship *Miner) Result {
+	return Result{
+		Data:   ship.Nav,
+		Header: []string{"SHIP", "STATUS", "WAYPOINT", "DESTINATION", "ARRIVAL"},
+		Rows:   [][]string{{ship.Symbol, string(ship.Nav.Status), ship.Nav.WaypointSymbol, ship.Nav.Route.Destination.Symbol, until(ship.Nav.Route.Arrival)}},
+	}
+}
+
+func transactionResult(agent client.Agent, trans client.MarketTransaction) Result {
+	return Result{
+		Data:   trans,
+		Header: []string{"TYPE", "GOOD", "UNITS", "PRICE", "TOTAL", "CREDITS"},
+		Rows: [][]string{{
+			string(trans.Type), trans.TradeSymbol, strconv.Itoa(trans.Units),
+			strconv.Itoa(trans.PricePerUnit), strconv.Itoa(trans.TotalPrice), strconv.Itoa(agent.Credits),
+		}},
+	}
+}
+
+func shipsCommand(account *Account, args []string) (Result, error) {
+	ships := []client.Ship{}
+	limit := 20
+	for page := 1; ; page++ {
+		resp, err := account.Client.GetMyShipsWithResponse(context.TODO(), &client.GetMyShipsParams{Page: &page, Limit: &limit})
+		if err != nil {
+			return Result{}, err
+		}
+		if resp.StatusCode() != 200 {
+			return Result{}, ParseAPIError(resp.StatusCode(), resp.Body)
+		}
+		ships = append(ships, resp.JSON200.Data...)
+		if len(resp.JSON200.Data) < limit || len(ships) >= resp.JSON200.Meta.Total {
+			break
+		}
+	}
+	result := Result{Data: ships, Header: shipHeader}
+	for _, ship := range ships {
+		result.Rows = append(result.Rows, shipRow(ship))
+	}
+	return result, nil
+}
+
+func shipCommand(account *Account, args []string) (Result, error) {
+	ship, err := loadShip(account, args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Data:   ship.Ship.Ship,
+		Header: append(append([]string{}, shipHeader...), "DESTINATION", "ARRIVAL", "INVENTORY"),
+		Rows:   [][]string{append(shipRow(ship.Ship.Ship), ship.Nav.Route.Destination.Symbol, until(ship.Nav.Route.Arrival), cargoSummary(ship.Cargo))},
+	}, nil
+}
+
+func navCommand(account *Account, args []string) (Result, error) {
+	ship, err := loadShip(account, args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	if ship.Status() == client.DOCKED {
+		if err := ship.Undock(); err != nil {
+			return Result{}, err
+		}
+	}
+	if err := ship.GoToSymbol(args[1]); err != nil {
+		return Result{}, err
+	}
+	return navResult(ship), nil
+}
+
+func dockCommand(account *Account, args []string) (Result, error) {
+	ship, err := loadShip(account, args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	if err := ship.Dock(); err != nil {
+		return Result{}, err
+	}
+	return navResult(ship), nil
+}
+
+func orbitCommand(account *Account, args []string) (Result, error) {
+	ship, err := loadShip(account, args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	if err := ship.Undock(); err != nil {
+		return Result{}, err
+	}
+	return navResult(ship), nil
+}
+
+func surveyCommand(account *Account, args []string) (Result, error) {
+	ship, err := loadShip(account, args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	surveys := ship.Survey()
+	if surveys == nil {
+		return Result{}, fmt.Errorf("survey failed")
+	}
+	result := Result{Data: surveys, Header: []string{"SIGNATURE", "SIZE", "EXPIRES", "DEPOSITS"}}
+	for _, survey := range surveys {
+		deposits := []string{}
+		for _, dep := range survey.Deposits {
+			deposits = append(deposits, dep.Symbol)
+		}
+		result.Rows = append(result.Rows, []string{survey.Signature, string(survey.Size), until(survey.Expiration), strings.Join(deposits, ", ")})
+	}
+	return result, nil
+}
+
+func extractCommand(account *Account, args []string) (Result, error) {
+	ship, err := loadShip(account, args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	extraction, err := ship.Extract()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Data:   extraction,
+		Header: []string{"GOOD", "UNITS", "CARGO", "COOLDOWN"},
+		Rows: [][]string{{
+			extraction.Yield.Symbol, strconv.Itoa(extraction.Yield.Units),
+			fmt.Sprintf("%d/%d", ship.Cargo.Units, ship.Cargo.Capacity), until(ship.Cooldown.Expiration),
+		}},
+	}, nil
+}
+
+func sellCommand(account *Account, args []string) (Result, error) {
+	ship, err := loadShip(account, args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	units, err := parseUnits(args[2])
+	if err != nil {
+		return Result{}, err
+	}
+	agent, trans, err := ship.SellCargo(args[1], units)
+	if err != nil {
+		return Result{}, err
+	}
+	return transactionResult(agent, trans), nil
+}
+
+func buyCommand(account *Account, args []string) (Result, error) {
+	ship, err := loadShip(account, args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	units, err := parseUnits(args[2])
+	if err != nil {
+		return Result{}, err
+	}
+	agent, trans, err := ship.Purchase(client.TradeSymbol(args[1]), units)
+	if err != nil {
+		return Result{}, err
+	}
+	return transactionResult(agent, trans), nil
+}
+
+func refuelCommand(account *Account, args []string) (Result, error) {
+	ship, err := loadShip(account, args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	agent, _, err := ship.Refuel()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Data:   ship.Fuel,
+		Header: []string{"SHIP", "FUEL", "CREDITS"},
+		Rows:   [][]string{{ship.Symbol, fmt.Sprintf("%d/%d", ship.Fuel.Current, ship.Fuel.Capacity), strconv.Itoa(agent.Credits)}},
+	}, nil
+}
+
+func contractRow(contract client.Contract) []string {
+	deliveries := []string{}
+	for _, deliver := range RemainingDeliveries(contract) {
+		deliveries = append(deliveries, fmt.Sprintf("%d/%d %s to %s", deliver.UnitsFulfilled, deliver.UnitsRequired, deliver.TradeSymbol, deliver.DestinationSymbol))
+	}
+	return []string{
+		contract.Id,
+		string(contract.Type),
+		strconv.FormatBool(contract.Accepted),
+		strconv.FormatBool(contract.Fulfilled),
+		strconv.Itoa(contract.Terms.Payment.OnAccepted + contract.Terms.Payment.OnFulfilled),
+		until(contract.Terms.Deadline),
+		strings.Join(deliveries, ", "),
+	}
+}
+
+var contractHeader = []string{"CONTRACT", "TYPE", "ACCEPTED", "FULFILLED", "PAYMENT", "DEADLINE", "REMAINING"}
+
+func contractsCommand(account *Account, args []string) (Result, error) {
+	game := NewGame(account)
+	game.FetchContracts()
+	result := Result{Data: game.State.Contracts, Header: contractHeader}
+	for _, contract := range game.State.Contracts {
+		result.Rows = append(result.Rows, contractRow(contract))
+	}
+	return result, nil
+}
+
+func acceptCommand(account *Account, args []string) (Result, error) {
+	game := NewGame(account)
+	game.FetchContracts()
+	contract := game.State.GetContract(args[0])
+	if contract == nil {
+		return Result{}, fmt.Errorf("unknown contract %q", args[0])
+	}
+	if err := game.AcceptContract(*contract); err != nil {
+		return Result{}, err
+	}
+	contract = game.State.GetContract(args[0])
+	return Result{Data: contract, Header: contractHeader, Rows: [][]string{contractRow(*contract)}}, nil
+}
+
+func marketCommand(account *Account, args []string) (Result, error) {
+	resp, err := account.Client.GetMarketWithResponse(context.TODO(), SystemSymbol(args[0]), args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	if resp.StatusCode() != 200 {
+		return Result{}, ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	market := resp.JSON200.Data
+	kinds := map[string]string{}
+	for kind, goods := range map[string][]client.TradeGood{"IMPORT": market.Imports, "EXPORT": market.Exports, "EXCHANGE": market.Exchange} {
+		for _, good := range goods {
+			kinds[string(good.Symbol)] = kind
+		}
+	}
+	prices := map[string]client.MarketTradeGood{}
+	if market.TradeGoods != nil {
+		for _, good := range *market.TradeGoods {
+			prices[good.Symbol] = good
+		}
+	}
+	result := Result{Data: market, Header: []string{"GOOD", "KIND", "SELL", "BUY", "SUPPLY", "VOLUME"}}
+	for _, good := range sortedKeys(kinds) {
+		row := []string{good, kinds[good], "", "", "", ""}
+		if price, ok := prices[good]; ok {
+			row[2], row[3] = strconv.Itoa(price.SellPrice), strconv.Itoa(price.PurchasePrice)
+			row[4], row[5] = string(price.Supply), strconv.Itoa(price.TradeVolume)
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result, nil
+}
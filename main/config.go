@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+const (
+	// CONFIG_ENV_PREFIX is the prefix of the environment variables that override the config file
+	CONFIG_ENV_PREFIX     = "SPACETRADERS_"
+	DEFAULT_SAVE_INTERVAL = 10 * time.Second
+	DEFAULT_POLL_INTERVAL = 500 * time.Millisecond
+	// DEFAULT_LOW_FUEL is the fraction of the fuel capacity below which a ship refuels
+	DEFAULT_LOW_FUEL = 0.25
+	// DEFAULT_MIN_TRADE_PROFIT is the least profit per unit a trade route has to make
+	DEFAULT_MIN_TRADE_PROFIT = 10
+)
+
+// Duration is a time.Duration that is written as "10s" in the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// Config is everything that can be set in the config file. Values missing from
+// the file keep their defaults.
+type Config struct {
+	APIURL    string `json:"api_url"`
+	StateFile string `json:"state_file"`
+	ConfigDir string `json:"config_dir"`
+	// Rate and Burst configure the rate limiter of agents that do not set their own
+	Rate     float64       `json:"rate"`
+	Burst    int           `json:"burst"`
+	Agents   []AgentConfig `json:"agents"`
+	Strategy Strategy      `json:"strategy"`
+	// Notifications configures who is told about significant events
+	Notifications NotificationConfig `json:"notifications"`
+}
+
+// Strategy holds the knobs that steer how the fleet plays.
+type Strategy struct {
+	SaveInterval          Duration `json:"save_interval"`
+	PollInterval          Duration `json:"poll_interval"`
+	ContractCheckInterval Duration `json:"contract_check_interval"`
+	FleetCheckInterval    Duration `json:"fleet_check_interval"`
+	// ReconcileInterval is how often every ship is compared with the server
+	ReconcileInterval Duration `json:"reconcile_interval"`
+	// CreditReserve is the amount of credits the fleet planner keeps when buying ships
+	CreditReserve int            `json:"credit_reserve"`
+	Miner         MinerStrategy  `json:"miner"`
+	Hauler        HaulerStrategy `json:"hauler"`
+	// Roles assigns ships to a role by symbol, overriding the role they are registered with
+	Roles map[string]string `json:"roles"`
+	// MarketBlacklist lists markets the fleet does not buy or sell at
+	MarketBlacklist []string `json:"market_blacklist"`
+}
+
+type MinerStrategy struct {
+	LowFuel float64 `json:"low_fuel"`
+}
+
+type HaulerStrategy struct {
+	LowFuel  float64  `json:"low_fuel"`
+	IdleWait Duration `json:"idle_wait"`
+	// MinTradeProfit is the least profit per unit for a trade route to be worth flying
+	MinTradeProfit int `json:"min_trade_profit"`
+}
+
+// ShipRole returns the role the ship is assigned, or else the role that matches
+// its registration.
+func (strategy *Strategy) ShipRole(ship client.Ship) string {
+	if role := strategy.Roles[ship.Symbol]; role != "" {
+		return role
+	}
+	switch ship.Registration.Role {
+	case client.ShipRoleHAULER, client.ShipRoleTRANSPORT:
+		return ROLE_HAULER
+	}
+	return ROLE_MINER
+}
+
+func (strategy *Strategy) IsBlacklisted(market string) bool {
+	return slices.Contains(strategy.MarketBlacklist, market)
+}
+
+// Roles a ship can be assigned in the config file.
+const (
+	ROLE_MINER  = "MINER"
+	ROLE_HAULER = "HAULER"
+)
+
+func DefaultConfig() Config {
+	return Config{
+		APIURL:    API_URL,
+		StateFile: STATE_FILE,
+		ConfigDir: DefaultConfigDir(),
+		Rate:      DEFAULT_RATE,
+		Burst:     DEFAULT_BURST,
+		Strategy:  DefaultStrategy(),
+	}
+}
+
+func DefaultStrategy() Strategy {
+	return Strategy{
+		SaveInterval:          Duration{DEFAULT_SAVE_INTERVAL},
+		PollInterval:          Duration{DEFAULT_POLL_INTERVAL},
+		ContractCheckInterval: Duration{CONTRACT_CHECK_INTERVAL},
+		FleetCheckInterval:    Duration{FLEET_CHECK_INTERVAL},
+		ReconcileInterval:     Duration{DEFAULT_RECONCILE_INTERVAL},
+		CreditReserve:         DEFAULT_CREDIT_RESERVE,
+		Miner:                 MinerStrategy{LowFuel: DEFAULT_LOW_FUEL},
+		Hauler:                HaulerStrategy{LowFuel: DEFAULT_LOW_FUEL, IdleWait: Duration{HAULER_IDLE_WAIT}, MinTradeProfit: DEFAULT_MIN_TRADE_PROFIT},
+		Roles:                 map[string]string{},
+	}
+}
+
+// LoadConfig reads the config file at path on top of the defaults. Unknown
+// fields are an error, so typos do not go unnoticed.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	f, err := os.Open(path)
+	if err != nil {
+		return config, err
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// ApplyEnv overrides the config with the SPACETRADERS_* environment variables that are set.
+func (config *Config) ApplyEnv() error {
+	errs := []error{}
+	str := func(name string, value *string) {
+		if s, ok := os.LookupEnv(CONFIG_ENV_PREFIX + name); ok {
+			*value = s
+		}
+	}
+	number := func(name string, set func(string) error) {
+		if s, ok := os.LookupEnv(CONFIG_ENV_PREFIX + name); ok {
+			if err := set(s); err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", CONFIG_ENV_PREFIX, name, err))
+			}
+		}
+	}
+	str("API_URL", &config.APIURL)
+	str("STATE_FILE", &config.StateFile)
+	str("CONFIG_DIR", &config.ConfigDir)
+	number("RATE", func(s string) (err error) {
+		config.Rate, err = strconv.ParseFloat(s, 64)
+		return err
+	})
+	number("BURST", func(s string) (err error) {
+		config.Burst, err = strconv.Atoi(s)
+		return err
+	})
+	number("CREDIT_RESERVE", func(s string) (err error) {
+		config.Strategy.CreditReserve, err = strconv.Atoi(s)
+		return err
+	})
+	return errors.Join(errs...)
+}
+
+// Validate reports every problem with the config at once.
+func (config *Config) Validate() error {
+	errs := []error{}
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(config.APIURL != "", "api_url is empty")
+	check(config.StateFile != "", "state_file is empty")
+	check(config.Rate > 0, "rate must be positive, got %v", config.Rate)
+	check(config.Burst > 0, "burst must be positive, got %d", config.Burst)
+	names := map[string]bool{}
+	for i, agent := range config.Agents {
+		check(agent.Name != "", "agent %d has no name", i+1)
+		check(agent.Name == "" || !names[agent.Name], "agent %s is configured twice", agent.Name)
+		check(agent.Rate >= 0, "agent %s: rate must not be negative", agent.Name)
+		check(agent.Burst >= 0, "agent %s: burst must not be negative", agent.Name)
+		names[agent.Name] = true
+	}
+	errs = append(errs, config.Strategy.Validate(), config.Notifications.Validate())
+	return errors.Join(errs...)
+}
+
+func (strategy *Strategy) Validate() error {
+	errs := []error{}
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(strategy.SaveInterval.Duration > 0, "strategy.save_interval must be positive")
+	check(strategy.PollInterval.Duration > 0, "strategy.poll_interval must be positive")
+	check(strategy.ContractCheckInterval.Duration > 0, "strategy.contract_check_interval must be positive")
+	check(strategy.FleetCheckInterval.Duration > 0, "strategy.fleet_check_interval must be positive")
+	check(strategy.ReconcileInterval.Duration > 0, "strategy.reconcile_interval must be positive")
+	check(strategy.CreditReserve >= 0, "strategy.credit_reserve must not be negative")
+	check(strategy.Miner.LowFuel >= 0 && strategy.Miner.LowFuel <= 1, "strategy.miner.low_fuel must be between 0 and 1, got %v", strategy.Miner.LowFuel)
+	check(strategy.Hauler.LowFuel >= 0 && strategy.Hauler.LowFuel <= 1, "strategy.hauler.low_fuel must be between 0 and 1, got %v", strategy.Hauler.LowFuel)
+	check(strategy.Hauler.IdleWait.Duration > 0, "strategy.hauler.idle_wait must be positive")
+	check(strategy.Hauler.MinTradeProfit >= 0, "strategy.hauler.min_trade_profit must not be negative")
+	for _, ship := range sortedKeys(strategy.Roles) {
+		role := strategy.Roles[ship]
+		check(role == ROLE_MINER || role == ROLE_HAULER, "strategy.roles: ship %s has unknown role %q, use %s or %s", ship, role, ROLE_MINER, ROLE_HAULER)
+	}
+	return errors.Join(errs...)
+}
+
+// AgentConfigs returns the agents to run, with defaults filled in from the rest
+// of the config. Without agents in the config there is a single default agent.
+func (config *Config) AgentConfigs() []AgentConfig {
+	if len(config.Agents) == 0 {
+		return []AgentConfig{{Name: DEFAULT_AGENT, ConfigDir: config.ConfigDir, StateFile: config.StateFile, Rate: config.Rate, Burst: config.Burst}}
+	}
+	configs := []AgentConfig{}
+	for _, agent := range config.Agents {
+		if agent.ConfigDir == "" {
+			agent.ConfigDir = filepath.Join(config.ConfigDir, agent.Name)
+		}
+		if agent.StateFile == "" {
+			agent.StateFile = "game." + agent.Name + ".state.json"
+		}
+		if agent.Rate == 0 {
+			agent.Rate = config.Rate
+		}
+		if agent.Burst == 0 {
+			agent.Burst = config.Burst
+		}
+		configs = append(configs, agent)
+	}
+	return configs
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+const (
+	CONTRACT_CHECK_INTERVAL   = time.Minute
+	CONTRACT_DEADLINE_WARNING = 6 * time.Hour
+	// EXTRACTION_RATE is a rough estimate of the units a single miner extracts per minute
+	EXTRACTION_RATE = 3.0
+)
+
+// RemainingDeliveries returns the deliveries of the contract that still need units.
+func RemainingDeliveries(contract client.Contract) []client.ContractDeliverGood {
+	remaining := []client.ContractDeliverGood{}
+	if contract.Terms.Deliver == nil {
+		return remaining
+	}
+	for _, deliver := range *contract.Terms.Deliver {
+		if deliver.UnitsFulfilled < deliver.UnitsRequired {
+			remaining = append(remaining, deliver)
+		}
+	}
+	return remaining
+}
+
+// IsContractComplete reports whether all deliveries of the contract have been made.
+func IsContractComplete(contract client.Contract) bool {
+	return len(RemainingDeliveries(contract)) == 0
+}
+
+// IsContractActive reports whether the contract is accepted and still needs work.
+func IsContractActive(contract client.Contract, now time.Time) bool {
+	return contract.Accepted && !contract.Fulfilled && contract.Terms.Deadline.After(now)
+}
+
+// EstimateContract estimates the profit of a contract and the time it takes to
+// mine the remaining units with the given number of miners. Mined units are valued
+// at the best known sell price, since that is what we give up by delivering them.
+func (state *State) EstimateContract(contract client.Contract, miners int) (int, time.Duration) {
+	profit := contract.Terms.Payment.OnFulfilled
+	if !contract.Accepted {
+		profit += contract.Terms.Payment.OnAccepted
+	}
+	units := 0
+	for _, deliver := range RemainingDeliveries(contract) {
+		remaining := deliver.UnitsRequired - deliver.UnitsFulfilled
+		units += remaining
+		profit -= remaining * state.BestSellPrice(deliver.TradeSymbol)
+	}
+	if miners < 1 {
+		miners = 1
+	}
+	minutes := float64(units) / (EXTRACTION_RATE * float64(miners))
+	return profit, time.Duration(minutes * float64(time.Minute))
+}
+
+// UpdateContract replaces the contract with the same id, or adds it if it is new.
+func (state *State) UpdateContract(contract client.Contract) {
+	for i, c := range state.Contracts {
+		if c.Id == contract.Id {
+			state.Contracts[i] = contract
+			return
+		}
+	}
+	state.Contracts = append(state.Contracts, contract)
+}
+
+// ActiveContract returns the active contract with the earliest deadline, or nil.
+func (state *State) ActiveContract() *client.Contract {
+	var active *client.Contract
+	for i, contract := range state.Contracts {
+		if !IsContractActive(contract, state.Clock.Now()) || IsContractComplete(contract) {
+			continue
+		}
+		if active == nil || contract.Terms.Deadline.Before(active.Terms.Deadline) {
+			active = &state.Contracts[i]
+		}
+	}
+	return active
+}
+
+func (state *State) CountMiners() int {
+	miners := 0
+	for _, ship := range state.Ships {
+		if _, ok := ship.(*Miner); ok {
+			miners++
+		}
+	}
+	return miners
+}
+
+func (game *Game) FetchContracts() {
+	resp, err := game.Account.Client.GetContractsWithResponse(context.TODO(), &client.GetContractsParams{})
+	if err != nil {
+		game.Log().Error("Failed to fetch contracts", "error", err)
+		return
+	}
+	if resp.StatusCode() != 200 {
+		game.Log().Error("Failed to fetch contracts", "error", ParseAPIError(resp.StatusCode(), resp.Body))
+		return
+	}
+	game.State.Contracts = resp.JSON200.Data
+}
+
+func (game *Game) AcceptContract(contract client.Contract) error {
+	resp, err := game.Account.Client.AcceptContractWithResponse(context.TODO(), contract.Id)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != 200 {
+		return ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	data := resp.JSON200.Data
+	game.State.Agent = data.Agent
+	game.State.UpdateContract(data.Contract)
+	game.Account.Events.Publish(ContractUpdated{EventInfo: game.event(), Change: CONTRACT_ACCEPTED, Contract: data.Contract, Payment: data.Contract.Terms.Payment.OnAccepted})
+	return nil
+}
+
+func (game *Game) FulfillContract(contract client.Contract) error {
+	resp, err := game.Account.Client.FulfillContractWithResponse(context.TODO(), contract.Id)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != 200 {
+		return ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	data := resp.JSON200.Data
+	game.State.Agent = data.Agent
+	game.State.UpdateContract(data.Contract)
+	game.Account.Events.Publish(ContractUpdated{EventInfo: game.event(), Change: CONTRACT_FULFILLED, Contract: data.Contract, Payment: data.Contract.Terms.Payment.OnFulfilled})
+	return nil
+}
+
+// CommittedTime estimates how long the fleet needs for the contracts it has
+// already accepted.
+func (state *State) CommittedTime(miners int) time.Duration {
+	committed := time.Duration(0)
+	for _, contract := range state.Contracts {
+		if IsContractActive(contract, state.Clock.Now()) {
+			_, duration := state.EstimateContract(contract, miners)
+			committed += duration
+		}
+	}
+	return committed
+}
+
+// ManageContracts accepts profitable offers the fleet has time for next to the
+// contracts it already works on, fulfils completed contracts and warns about
+// approaching deadlines. The task planners pick up the active contract.
+func (game *Game) ManageContracts() {
+	miners := game.State.CountMiners()
+	now := game.Clock.Now()
+	committed := game.State.CommittedTime(miners)
+	for _, contract := range game.State.Contracts {
+		if contract.Fulfilled {
+			continue
+		}
+		if !contract.Accepted {
+			if contract.Expiration.Before(now) || game.declined[contract.Id] {
+				continue
+			}
+			profit, duration := game.State.EstimateContract(contract, miners)
+			if profit <= 0 || now.Add(committed+duration).After(contract.Terms.Deadline) {
+				game.Log().Info("Declining contract", "contract", contract.Id, "profit", profit, "duration", duration.Round(time.Minute), "committed", committed.Round(time.Minute))
+				game.declined[contract.Id] = true
+				continue
+			}
+			if err := game.AcceptContract(contract); err != nil {
+				game.Log().Error("Failed to accept contract", "contract", contract.Id, "error", err)
+				continue
+			}
+			committed += duration
+			game.Log().Info("Accepted contract", "contract", contract.Id, "profit", profit, "duration", duration.Round(time.Minute))
+		} else if IsContractComplete(contract) {
+			if err := game.FulfillContract(contract); err != nil {
+				game.Log().Error("Failed to fulfill contract", "contract", contract.Id, "error", err)
+				continue
+			}
+			game.Log().Info("Fulfilled contract", "contract", contract.Id, "payment", contract.Terms.Payment.OnFulfilled, "credits", game.State.Agent.Credits)
+		} else if left := contract.Terms.Deadline.Sub(now); left < CONTRACT_DEADLINE_WARNING {
+			for _, deliver := range RemainingDeliveries(contract) {
+				game.Log().Warn("Contract deadline approaching", "contract", contract.Id, "left", left.Round(time.Minute), "good", deliver.TradeSymbol, "fulfilled", deliver.UnitsFulfilled, "required", deliver.UnitsRequired)
+			}
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+const (
+	// DASHBOARD_INTERVAL is how often the dashboard takes a new snapshot of the game
+	DASHBOARD_INTERVAL = time.Second
+	// DASHBOARD_EVENTS is how many recent events the dashboard shows per agent
+	DASHBOARD_EVENTS = 20
+)
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"percent": func(part, whole int) int {
+		if whole == 0 {
+			return 0
+		}
+		return 100 * part / whole
+	},
+}).Parse(dashboardHTML))
+
+type DashboardShip struct {
+	Symbol        string
+	Role          string
+	State         string
+	Location      string
+	NavStatus     string
+	Arrival       time.Duration
+	CargoUnits    int
+	CargoCapacity int
+	Cargo         string
+	Fuel          int
+	FuelCapacity  int
+	Cooldown      time.Duration
+	CooldownTotal time.Duration
+}
+
+type DashboardDelivery struct {
+	Good        string
+	Destination string
+	Fulfilled   int
+	Required    int
+}
+
+type DashboardContract struct {
+	Id         string
+	Type       string
+	Payment    int
+	Deadline   time.Duration
+	Deliveries []DashboardDelivery
+}
+
+type DashboardGood struct {
+	Symbol        string
+	SellPrice     int
+	PurchasePrice int
+	Supply        string
+}
+
+type DashboardMarket struct {
+	Symbol  string
+	Imports string
+	Exports string
+	Goods   []DashboardGood
+}
+
+// Snapshot is a copy of everything the dashboard shows, so it can be rendered
+// without touching the game state.
+type Snapshot struct {
+	// Name is the name the agent is configured with
+	Name      string
+	Time      time.Time
+	Agent     string
+	Credits   int
+	Ships     []DashboardShip
+	Contracts []DashboardContract
+	Markets   []DashboardMarket
+	Events    []DashboardEvent
+}
+
+type DashboardEvent struct {
+	Time        time.Time
+	Description string
+}
+
+// Dashboard serves a status page of the fleets of all agents and pushes updates
+// to open pages with server-sent events.
+type Dashboard struct {
+	mu          sync.Mutex
+	snapshots   map[string]Snapshot
+	events      map[string][]DashboardEvent
+	subscribers map[chan string]struct{}
+}
+
+func NewDashboard() *Dashboard {
+	return &Dashboard{snapshots: map[string]Snapshot{}, events: map[string][]DashboardEvent{}, subscribers: map[chan string]struct{}{}}
+}
+
+// Board is the dashboard the game loop keeps up to date.
+var Board = NewDashboard()
+
+// NewSnapshot copies what the dashboard shows out of the game state of the agent.
+func NewSnapshot(name string, state *State) Snapshot {
+	now := state.Clock.Now()
+	snapshot := Snapshot{
+		Name:    name,
+		Time:    now,
+		Agent:   state.Agent.Symbol,
+		Credits: state.Agent.Credits,
+	}
+	for _, s := range state.Ships {
+		ship := baseShip(s)
+		if ship == nil {
+			continue
+		}
+		cargo := []string{}
+		for _, item := range ship.Cargo.Inventory {
+			cargo = append(cargo, fmt.Sprintf("%d %s", item.Units, item.Symbol))
+		}
+		info := DashboardShip{
+			Symbol:        ship.Symbol,
+			Role:          string(ship.Registration.Role),
+			State:         ShipState(s),
+			Location:      ship.Nav.WaypointSymbol,
+			NavStatus:     string(ship.Nav.Status),
+			CargoUnits:    ship.Cargo.Units,
+			CargoCapacity: ship.Cargo.Capacity,
+			Cargo:         strings.Join(cargo, ", "),
+			Fuel:          ship.Fuel.Current,
+			FuelCapacity:  ship.Fuel.Capacity,
+		}
+		if ship.Nav.Status == client.INTRANSIT {
+			info.Arrival = ship.Nav.Route.Arrival.Sub(now).Round(time.Second)
+		}
+		if ship.Cooldown.Expiration.After(now) {
+			info.Cooldown = ship.Cooldown.Expiration.Sub(now).Round(time.Second)
+			info.CooldownTotal = time.Duration(ship.Cooldown.TotalSeconds) * time.Second
+		}
+		snapshot.Ships = append(snapshot.Ships, info)
+	}
+	for _, contract := range state.Contracts {
+		if !IsContractActive(contract, now) {
+			continue
+		}
+		info := DashboardContract{
+			Id:       contract.Id,
+			Type:     string(contract.Type),
+			Payment:  contract.Terms.Payment.OnFulfilled,
+			Deadline: contract.Terms.Deadline.Sub(now).Round(time.Minute),
+		}
+		if contract.Terms.Deliver != nil {
+			for _, deliver := range *contract.Terms.Deliver {
+				info.Deliveries = append(info.Deliveries, DashboardDelivery{
+					Good:        deliver.TradeSymbol,
+					Destination: deliver.DestinationSymbol,
+					Fulfilled:   deliver.UnitsFulfilled,
+					Required:    deliver.UnitsRequired,
+				})
+			}
+		}
+		snapshot.Contracts = append(snapshot.Contracts, info)
+	}
+	for _, symbol := range sortedKeys(state.Markets) {
+		market := state.Markets[symbol]
+		info := DashboardMarket{
+			Symbol:  symbol,
+			Imports: joinGoods(market.Imports),
+			Exports: joinGoods(market.Exports),
+		}
+		if market.TradeGoods != nil {
+			for _, good := range *market.TradeGoods {
+				info.Goods = append(info.Goods, DashboardGood{
+					Symbol:        good.Symbol,
+					SellPrice:     good.SellPrice,
+					PurchasePrice: good.PurchasePrice,
+					Supply:        string(good.Supply),
+				})
+			}
+			sort.Slice(info.Goods, func(i, j int) bool {
+				return info.Goods[i].Symbol < info.Goods[j].Symbol
+			})
+		}
+		snapshot.Markets = append(snapshot.Markets, info)
+	}
+	return snapshot
+}
+
+// Update takes a new snapshot of the agent at most every DASHBOARD_INTERVAL and
+// sends it to all open pages. It is called from the game loop.
+func (d *Dashboard) Update(name string, state *State) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if state.Clock.Now().Sub(d.snapshots[name].Time) < DASHBOARD_INTERVAL {
+		return
+	}
+	d.snapshots[name] = NewSnapshot(name, state)
+	b := &strings.Builder{}
+	if err := dashboardTemplate.ExecuteTemplate(b, "status", d.sortedSnapshots()); err != nil {
+		panic(err)
+	}
+	for ch := range d.subscribers {
+		// Pages that are slow to read simply miss an update
+		select {
+		case ch <- b.String():
+		default:
+		}
+	}
+}
+
+// Handle keeps the most recent events of each agent, to show them with the
+// next snapshot.
+func (d *Dashboard) Handle(event Event) {
+	description := DescribeEvent(event)
+	if description == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	info := event.Info()
+	events := append(d.events[info.Agent], DashboardEvent{Time: info.Time, Description: description})
+	if len(events) > DASHBOARD_EVENTS {
+		events = events[len(events)-DASHBOARD_EVENTS:]
+	}
+	d.events[info.Agent] = events
+}
+
+func (d *Dashboard) sortedSnapshots() []Snapshot {
+	snapshots := []Snapshot{}
+	for _, name := range sortedKeys(d.snapshots) {
+		snapshot := d.snapshots[name]
+		// newest first
+		for i := len(d.events[name]) - 1; i >= 0; i-- {
+			snapshot.Events = append(snapshot.Events, d.events[name][i])
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
+
+func (d *Dashboard) subscribe() chan string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ch := make(chan string, 1)
+	d.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (d *Dashboard) unsubscribe(ch chan string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.subscribers, ch)
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		d.mu.Lock()
+		snapshots := d.sortedSnapshots()
+		d.mu.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardTemplate.ExecuteTemplate(w, "page", snapshots); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	case "/events":
+		d.serveEvents(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveEvents streams every rendered update as a server-sent event.
+func (d *Dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	ch := d.subscribe()
+	defer d.unsubscribe(ch)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case html := <-ch:
+			for _, line := range strings.Split(html, "\n") {
+				fmt.Fprintf(w, "data: %s\n", line)
+			}
+			fmt.Fprint(w, "\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func joinGoods(goods []client.TradeGood) string {
+	symbols := []string{}
+	for _, good := range goods {
+		symbols = append(symbols, string(good.Symbol))
+	}
+	return strings.Join(symbols, ", ")
+}
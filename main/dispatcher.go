@@ -0,0 +1,455 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+type TaskKind string
+
+const (
+	TASK_MINE    TaskKind = "MINE"
+	TASK_DELIVER TaskKind = "DELIVER"
+	TASK_TRADE   TaskKind = "TRADE"
+	TASK_SCOUT   TaskKind = "SCOUT"
+	TASK_REFUEL  TaskKind = "REFUEL"
+)
+
+// taskPriority orders the task kinds. Higher priority tasks are assigned first
+// and may take a ship off a standing task of lower priority.
+var taskPriority = map[TaskKind]int{
+	TASK_REFUEL:  40,
+	TASK_DELIVER: 30,
+	TASK_MINE:    20,
+	TASK_TRADE:   10,
+	TASK_SCOUT:   5,
+}
+
+const (
+	// PREEMPT_PENALTY makes the dispatcher prefer idle ships over ships it has to
+	// take off a standing task
+	PREEMPT_PENALTY = 1e6
+	// MINER_CARGO_PENALTY makes the dispatcher prefer other ships for carrying goods
+	MINER_CARGO_PENALTY = 1e5
+)
+
+// Task is a job for a ship. Standing tasks, like mining a field, are never done;
+// every capable ship without anything else to do works on them.
+type Task struct {
+	Id       int
+	Kind     TaskKind
+	Standing bool
+	// For restricts the task to a single ship
+	For string
+	// Ship is the ship working on the task, if it is not a standing task
+	Ship string
+	// Waypoint is the field to mine, or the market to scout or refuel at
+	Waypoint string
+	// ContractId and Good are the contract a MINE task works for and the good it needs
+	ContractId string
+	Good       string
+	// Order is what DELIVER and TRADE tasks buy and where they take it
+	Order     *Procurement
+	Done      bool
+	Cancelled bool
+}
+
+func (task *Task) String() string {
+	switch task.Kind {
+	case TASK_DELIVER, TASK_TRADE:
+		return fmt.Sprintf("%s %d %s %s→%s", task.Kind, task.Order.Units, task.Order.TradeSymbol, task.Order.Market, task.Order.Destination)
+	case TASK_MINE:
+		if task.Good != "" {
+			return fmt.Sprintf("%s %s at %s", task.Kind, task.Good, task.Waypoint)
+		}
+	}
+	return fmt.Sprintf("%s at %s", task.Kind, task.Waypoint)
+}
+
+// key identifies what a task does, so the same work is not queued twice.
+func (task *Task) key() string {
+	switch task.Kind {
+	case TASK_DELIVER:
+		return fmt.Sprint(task.Kind, "/", task.Order.ContractId, "/", task.Order.TradeSymbol)
+	case TASK_TRADE:
+		return fmt.Sprint(task.Kind, "/", task.Order.TradeSymbol, "/", task.Order.Market, "/", task.Order.Destination)
+	}
+	return fmt.Sprint(task.Kind, "/", task.For, "/", task.Waypoint, "/", task.ContractId, "/", task.Good)
+}
+
+// location is where a ship has to go first for the task.
+func (task *Task) location() string {
+	if task.Order != nil {
+		return task.Order.Market
+	}
+	return task.Waypoint
+}
+
+// Dispatcher keeps the queue of tasks of an agent. Planners add tasks, and the
+// game loop hands them out to idle ships with Game.Dispatch.
+type Dispatcher struct {
+	Tasks  []*Task
+	nextId int
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{}
+}
+
+// Add queues the task unless the same work is already queued or being done. It
+// returns whether the task was added.
+func (d *Dispatcher) Add(task *Task) bool {
+	if d.Has(task.key()) {
+		return false
+	}
+	d.nextId++
+	task.Id = d.nextId
+	d.Tasks = append(d.Tasks, task)
+	return true
+}
+
+func (d *Dispatcher) Has(key string) bool {
+	for _, task := range d.Tasks {
+		if !task.Done && !task.Cancelled && task.key() == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns how many tasks of the kind are queued or being done.
+func (d *Dispatcher) Count(kind TaskKind) int {
+	count := 0
+	for _, task := range d.Tasks {
+		if !task.Done && !task.Cancelled && task.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// Retire cancels the tasks of the kind whose key is not in keep. Ships drop
+// cancelled tasks at their next decision.
+func (d *Dispatcher) Retire(kind TaskKind, keep map[string]bool) {
+	for _, task := range d.Tasks {
+		if task.Kind == kind && !keep[task.key()] {
+			task.Cancelled = true
+		}
+	}
+}
+
+// prune forgets finished and cancelled tasks.
+func (d *Dispatcher) prune() {
+	tasks := d.Tasks[:0]
+	for _, task := range d.Tasks {
+		if !task.Done && !task.Cancelled {
+			tasks = append(tasks, task)
+		}
+	}
+	d.Tasks = tasks
+}
+
+// queued returns the standing tasks and the tasks no ship works on, highest
+// priority first.
+func (d *Dispatcher) queued() []*Task {
+	queued := []*Task{}
+	for _, task := range d.Tasks {
+		if task.Standing || task.Ship == "" {
+			queued = append(queued, task)
+		}
+	}
+	sort.SliceStable(queued, func(i, j int) bool {
+		return taskPriority[queued[i].Kind] > taskPriority[queued[j].Kind]
+	})
+	return queued
+}
+
+// CanTake reports whether the ship is able to do the task: it has to be in the
+// same system, mining takes a miner and carrying goods takes cargo space.
+func CanTake(ship BaseShip, task *Task) bool {
+	s := baseShip(ship)
+	if task.For != "" && task.For != s.Symbol {
+		return false
+	}
+	if SystemSymbol(task.location()) != s.Nav.SystemSymbol {
+		return false
+	}
+	switch task.Kind {
+	case TASK_MINE:
+		_, miner := ship.(*Miner)
+		return miner
+	case TASK_DELIVER, TASK_TRADE:
+		return s.Cargo.Units < s.Cargo.Capacity || s.CargoUnits(task.Order.TradeSymbol) > 0
+	}
+	return true
+}
+
+// taskScore rates how well the ship suits the task, lower is better: close
+// ships are better, and for carrying goods so are ships with room or with the
+// goods already on board.
+func taskScore(ship BaseShip, task *Task, state *State) float64 {
+	s := baseShip(ship)
+	score := state.Distance(s.Nav.WaypointSymbol, task.location())
+	if s.Task != nil {
+		score += PREEMPT_PENALTY
+	}
+	if task.Order != nil {
+		if _, miner := ship.(*Miner); miner {
+			score += MINER_CARGO_PENALTY
+		}
+		score -= float64(s.Cargo.Capacity - s.Cargo.Units + s.CargoUnits(task.Order.TradeSymbol))
+	}
+	return score
+}
+
+// Distance returns the distance between two known waypoints, or 0 if either is unknown.
+func (state *State) Distance(from string, to string) float64 {
+	a, okA := state.Waypoints[from]
+	b, okB := state.Waypoints[to]
+	if !okA || !okB {
+		return 0
+	}
+	return math.Hypot(float64(a.X-b.X), float64(a.Y-b.Y))
+}
+
+// Dispatch hands out the queued tasks. Standing tasks go to every capable ship
+// without a task, other tasks to the best suited ship that is idle or on a
+// standing task of lower priority.
+func (game *Game) Dispatch() {
+	game.Tasks.prune()
+	for _, task := range game.Tasks.queued() {
+		if task.Standing {
+			for _, ship := range game.State.Ships {
+				s := baseShip(ship)
+				if s.Task == nil && CanTake(ship, task) {
+					s.Task = task
+					game.Log().Info("Assigned task", "ship", s.Symbol, "task", task.String())
+				}
+			}
+			continue
+		}
+		var best BaseShip
+		bestScore := 0.0
+		for _, ship := range game.State.Ships {
+			s := baseShip(ship)
+			available := s.Task == nil || s.Task.Standing && taskPriority[s.Task.Kind] < taskPriority[task.Kind]
+			if !available || !CanTake(ship, task) {
+				continue
+			}
+			if score := taskScore(ship, task, &game.State); best == nil || score < bestScore {
+				best, bestScore = ship, score
+			}
+		}
+		if best == nil {
+			continue
+		}
+		s := baseShip(best)
+		if s.Task != nil {
+			game.State.ReleaseSurveying(s.Nav.WaypointSymbol, s.Symbol)
+			game.Log().Info("Taking ship off its task", "ship", s.Symbol, "task", s.Task.String())
+		}
+		s.Task = task
+		task.Ship = s.Symbol
+		game.Log().Info("Assigned task", "ship", s.Symbol, "task", task.String())
+	}
+}
+
+// PlanTasks refreshes the task queue from the contracts, markets and ships.
+func (game *Game) PlanTasks() {
+	game.PlanMining()
+	game.PlanProcurement()
+	game.PlanRefuels()
+	game.PlanTrades()
+	game.PlanScouting()
+}
+
+// PlanMining has the miners of every system mine its asteroid field, for the
+// active contract if there is one.
+func (game *Game) PlanMining() {
+	contractId, good := "", ""
+	if contract := game.State.ActiveContract(); contract != nil {
+		contractId = contract.Id
+		if remaining := RemainingDeliveries(*contract); len(remaining) > 0 {
+			good = remaining[0].TradeSymbol
+		}
+	}
+	keep := map[string]bool{}
+	for _, ship := range game.State.Ships {
+		if _, miner := ship.(*Miner); !miner {
+			continue
+		}
+		field := game.State.GetAsteroid(baseShip(ship).Nav.SystemSymbol)
+		if field == nil {
+			continue
+		}
+		task := &Task{Kind: TASK_MINE, Standing: true, Waypoint: field.Symbol, ContractId: contractId, Good: good}
+		keep[task.key()] = true
+		game.Tasks.Add(task)
+	}
+	game.Tasks.Retire(TASK_MINE, keep)
+}
+
+// PlanRefuels sends ships without a task that run low on fuel to the closest
+// market that sells fuel. Miners look after their own fuel.
+func (game *Game) PlanRefuels() {
+	for _, ship := range game.State.Ships {
+		hauler, ok := ship.(*Hauler)
+		if !ok || hauler.Task != nil || !hauler.HasLowFuel(game.Account.Strategy.Hauler.LowFuel) {
+			continue
+		}
+		market := game.State.ClosestFuel(hauler.Nav.WaypointSymbol, game.Account.Strategy.IsBlacklisted)
+		if market == "" {
+			continue
+		}
+		game.Tasks.Add(&Task{Kind: TASK_REFUEL, For: hauler.Symbol, Waypoint: market})
+	}
+}
+
+// ClosestFuel returns the closest known market in the same system that sells fuel.
+func (state *State) ClosestFuel(from string, skip func(market string) bool) string {
+	best := ""
+	bestDistance := 0.0
+	for symbol := range state.Markets {
+		if skip(symbol) || SystemSymbol(symbol) != SystemSymbol(from) || state.MarketTradeGood(symbol, string(client.TradeSymbolFUEL)) == nil {
+			continue
+		}
+		if distance := state.Distance(from, symbol); best == "" || distance < bestDistance {
+			best, bestDistance = symbol, distance
+		}
+	}
+	return best
+}
+
+// PlanTrades queues the most profitable trade route of the systems we have
+// haulers in, as long as there are more haulers than trades.
+func (game *Game) PlanTrades() {
+	systems := map[string]bool{}
+	haulers := 0
+	for _, ship := range game.State.Ships {
+		if hauler, ok := ship.(*Hauler); ok {
+			systems[hauler.Nav.SystemSymbol] = true
+			haulers++
+		}
+	}
+	for _, system := range sortedKeys(systems) {
+		if game.Tasks.Count(TASK_TRADE) >= haulers {
+			break
+		}
+		order, profit := game.State.BestTrade(system, game.Account.Strategy.Hauler.MinTradeProfit, game.Account.Strategy.IsBlacklisted)
+		if order == nil {
+			continue
+		}
+		if game.Tasks.Add(&Task{Kind: TASK_TRADE, Order: order}) {
+			game.Log().Info("Planned trade", "good", order.TradeSymbol, "from", order.Market, "to", order.Destination, "units", order.Units, "profit_per_unit", profit)
+		}
+	}
+}
+
+// BestTrade finds the good in the system with the highest profit for a full
+// trade volume when bought at one known market and sold at another, if the
+// profit per unit is at least minProfit.
+func (state *State) BestTrade(system string, minProfit int, skip func(market string) bool) (*Procurement, int) {
+	var best *Procurement
+	bestProfit, bestTotal := 0, 0
+	for _, from := range sortedKeys(state.Markets) {
+		buy := state.Markets[from]
+		if SystemSymbol(from) != system || skip(from) || buy.TradeGoods == nil {
+			continue
+		}
+		for _, tg := range *buy.TradeGoods {
+			if tg.PurchasePrice == 0 || tg.TradeVolume == 0 {
+				continue
+			}
+			for _, to := range sortedKeys(state.Markets) {
+				sell := state.MarketTradeGood(to, tg.Symbol)
+				if to == from || SystemSymbol(to) != system || skip(to) || sell == nil {
+					continue
+				}
+				profit := sell.SellPrice - tg.PurchasePrice
+				if profit < minProfit || profit <= 0 || profit*tg.TradeVolume <= bestTotal {
+					continue
+				}
+				best = &Procurement{TradeSymbol: tg.Symbol, Market: from, Destination: to, Units: tg.TradeVolume}
+				bestProfit, bestTotal = profit, profit*tg.TradeVolume
+			}
+		}
+	}
+	return best, bestProfit
+}
+
+// PlanScouting queues a visit to every market in our systems we have never seen.
+func (game *Game) PlanScouting() {
+	systems := map[string]bool{}
+	for _, ship := range game.State.Ships {
+		systems[baseShip(ship).Nav.SystemSymbol] = true
+	}
+	for system := range systems {
+		for _, wp := range game.State.WaypointsBySystem[system] {
+			if _, known := game.State.Markets[wp.Symbol]; known || !wp.HasMarket() || game.Account.Strategy.IsBlacklisted(wp.Symbol) {
+				continue
+			}
+			game.Tasks.Add(&Task{Kind: TASK_SCOUT, Waypoint: wp.Symbol})
+		}
+	}
+}
+
+// RunTask takes a single step in the ship's task, other than mining. It returns
+// true once the task is done.
+func (ship *Ship) RunTask(gameState *State) bool {
+	task := ship.Task
+	switch task.Kind {
+	case TASK_DELIVER, TASK_TRADE:
+		return ship.RunProcurement(task.Order, gameState)
+	case TASK_SCOUT:
+		if !ship.moveForTask(task) {
+			return false
+		}
+		market, err := ship.UpdateMarket()
+		if err == nil {
+			gameState.UpdateMarket(market)
+		}
+	case TASK_REFUEL:
+		if !ship.moveForTask(task) {
+			return false
+		}
+		agent, _, err := ship.Refuel()
+		if err != nil {
+			ship.Log().Error("Failed to refuel", "error", err)
+			return true
+		}
+		gameState.Agent = agent
+	}
+	return true
+}
+
+// moveForTask takes a step towards the waypoint of the task, returning true once
+// the ship is docked there. A failed step is tried again on the next run.
+func (ship *Ship) moveForTask(task *Task) bool {
+	moved, err := ship.MoveTo(task.Waypoint)
+	if err != nil {
+		ship.Log().Warn("Failed to move for task, trying again", "task", task.String(), "error", err)
+	}
+	return moved
+}
+
+// FinishTask marks the ship's task as done.
+func (ship *Ship) FinishTask() {
+	ship.Log().Info("Finished task", "task", ship.Task.String())
+	ship.Task.Done = true
+	ship.Task = nil
+}
+
+// DropTask stops working on the task, leaving it to another ship unless it was
+// cancelled. An order starts over at its market, keeping what was delivered.
+func (ship *Ship) DropTask() {
+	if !ship.Task.Standing {
+		ship.Task.Ship = ""
+	}
+	if ship.Task.Order != nil {
+		ship.Task.Order.State = ""
+	}
+	ship.Task = nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+	"github.com/Dutchy-/spacetrader-go/main/clock"
+)
+
+// testOutpost is a market far from the station and the field.
+const testOutpost = "X1-TEST-C1"
+
+// dispatchGame returns a game without ships in a system with the station at
+// 0,0, the field at 10,0 and the outpost at 100,0.
+func dispatchGame(t *testing.T) *Game {
+	strategy := DefaultStrategy()
+	account := NewAccount(AgentConfig{Name: "test", Rate: 1000, Burst: 100}, &strategy)
+	account.StatePath = filepath.Join(t.TempDir(), "game.state.json")
+	game := NewGame(account)
+	game.SetClock(clock.NewFake(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)))
+	account.Events.Subscribe(game.Handle)
+	outpost := client.ScannedWaypoint{Symbol: testOutpost, SystemSymbol: testSystem, Type: client.WaypointTypePLANET, X: 100,
+		Traits: []client.WaypointTrait{{Symbol: client.WaypointTraitSymbolMARKETPLACE}}}
+	field := testAsteroid
+	field.X = 10
+	game.State.AddWaypoints(testSystem, []client.ScannedWaypoint{testMarketplace, field, outpost})
+	return game
+}
+
+// addShip adds a miner or hauler to the game at the waypoint.
+func addShip(game *Game, role string, symbol string, waypoint string) *Ship {
+	ship := client.Ship{Symbol: symbol, Nav: client.ShipNav{Status: client.DOCKED, SystemSymbol: testSystem, WaypointSymbol: waypoint},
+		Cargo: testCargo(0), Fuel: client.ShipFuel{Capacity: 100, Current: 100}}
+	var added BaseShip = &Miner{Ship: Ship{Ship: ship, Clock: game.Clock, Account: game.Account}}
+	if role == ROLE_HAULER {
+		added = &Hauler{Ship: Ship{Ship: ship, Clock: game.Clock, Account: game.Account}}
+	}
+	game.State.Ships = append(game.State.Ships, added)
+	return baseShip(added)
+}
+
+func deliverTask() *Task {
+	return &Task{Kind: TASK_DELIVER, Order: &Procurement{ContractId: "test-contract", TradeSymbol: string(client.TradeSymbolICEWATER), Market: testStation, Destination: testOutpost, Units: 100}}
+}
+
+func TestDispatchPriority(t *testing.T) {
+	game := dispatchGame(t)
+	miner := addShip(game, ROLE_MINER, "TEST-1", testField)
+	lowFuel := addShip(game, ROLE_HAULER, "TEST-2", testOutpost)
+	near := addShip(game, ROLE_HAULER, "TEST-3", testField)
+	far := addShip(game, ROLE_HAULER, "TEST-4", testOutpost)
+	// added lowest priority first, so the order they are queued in does not matter
+	scout := &Task{Kind: TASK_SCOUT, Waypoint: testOutpost}
+	trade := &Task{Kind: TASK_TRADE, Order: &Procurement{TradeSymbol: string(client.TradeSymbolFUEL), Market: testStation, Destination: testOutpost, Units: 10}}
+	mine := &Task{Kind: TASK_MINE, Standing: true, Waypoint: testField}
+	deliver := deliverTask()
+	refuel := &Task{Kind: TASK_REFUEL, For: lowFuel.Symbol, Waypoint: testStation}
+	for _, task := range []*Task{scout, trade, mine, deliver, refuel} {
+		game.Tasks.Add(task)
+	}
+
+	game.Dispatch()
+	for _, want := range []struct {
+		ship *Ship
+		task *Task
+	}{
+		{lowFuel, refuel},
+		// the closest hauler delivers, the miner is kept off carrying goods
+		{near, deliver},
+		{miner, mine},
+		{far, trade},
+	} {
+		if want.ship.Task != want.task {
+			t.Errorf("%s has task %v, want %v", want.ship.Symbol, want.ship.Task, want.task)
+		}
+	}
+	if scout.Ship != "" {
+		t.Errorf("scouting assigned to %s while every ship has a task", scout.Ship)
+	}
+}
+
+func TestDispatchTakesShipOffStandingTask(t *testing.T) {
+	game := dispatchGame(t)
+	miner := addShip(game, ROLE_MINER, "TEST-1", testField)
+	mine := &Task{Kind: TASK_MINE, Standing: true, Waypoint: testField}
+	game.Tasks.Add(mine)
+	game.Dispatch()
+	if miner.Task != mine {
+		t.Fatalf("miner has task %v, want %v", miner.Task, mine)
+	}
+
+	// scouting is less important than mining, delivering more
+	scout := &Task{Kind: TASK_SCOUT, Waypoint: testOutpost}
+	game.Tasks.Add(scout)
+	game.Dispatch()
+	if miner.Task != mine || scout.Ship != "" {
+		t.Errorf("miner taken off mining to scout")
+	}
+	deliver := deliverTask()
+	game.Tasks.Add(deliver)
+	game.Dispatch()
+	if miner.Task != deliver || deliver.Ship != miner.Symbol {
+		t.Errorf("miner has task %v, want %v", miner.Task, deliver)
+	}
+	if mine.Done || mine.Cancelled {
+		t.Errorf("standing mining task ended when a ship was taken off it")
+	}
+}
+
+func TestDispatchAssignsTasksOnce(t *testing.T) {
+	game := dispatchGame(t)
+	ships := []*Ship{
+		addShip(game, ROLE_HAULER, "TEST-1", testStation),
+		addShip(game, ROLE_HAULER, "TEST-2", testStation),
+		addShip(game, ROLE_MINER, "TEST-3", testField),
+		addShip(game, ROLE_MINER, "TEST-4", testField),
+	}
+	deliver := deliverTask()
+	game.Tasks.Add(deliver)
+	// the same work is not queued twice
+	if game.Tasks.Add(deliverTask()) {
+		t.Error("second task for the same delivery queued")
+	}
+	mine := &Task{Kind: TASK_MINE, Standing: true, Waypoint: testField}
+	game.Tasks.Add(mine)
+	for i := 0; i < 3; i++ {
+		game.Dispatch()
+	}
+	counts := map[*Task]int{}
+	for _, ship := range ships {
+		if ship.Task != nil {
+			counts[ship.Task]++
+		}
+	}
+	if counts[deliver] != 1 {
+		t.Errorf("delivery assigned to %d ships, want 1", counts[deliver])
+	}
+	// a standing task is worked on by every capable ship
+	if counts[mine] != 2 {
+		t.Errorf("mining assigned to %d ships, want both miners", counts[mine])
+	}
+}
+
+func TestPlanTasks(t *testing.T) {
+	game := dispatchGame(t)
+	addShip(game, ROLE_MINER, "TEST-1", testField)
+	hauler := addShip(game, ROLE_HAULER, "TEST-2", testStation)
+	hauler.Fuel.Current = 10
+	contract := testContract(0)
+	contract.Type = client.ContractTypePROCUREMENT
+	contract.Terms.Deadline = game.Clock.Now().Add(24 * time.Hour)
+	contract.Terms.Payment = client.ContractPayment{OnAccepted: 1000, OnFulfilled: 4000}
+	game.State.Contracts = []client.Contract{contract}
+	// ice water is cheaper to buy than the contract pays, fuel sells for more at
+	// the outpost, which was never seen
+	game.State.Markets[testStation] = client.Market{Symbol: testStation, TradeGoods: &[]client.MarketTradeGood{
+		{Symbol: string(client.TradeSymbolICEWATER), PurchasePrice: 10, SellPrice: 8, TradeVolume: 10},
+		{Symbol: string(client.TradeSymbolFUEL), PurchasePrice: 50, SellPrice: 40, TradeVolume: 10},
+	}}
+	game.State.Markets["X1-TEST-D1"] = client.Market{Symbol: "X1-TEST-D1", TradeGoods: &[]client.MarketTradeGood{
+		{Symbol: string(client.TradeSymbolFUEL), PurchasePrice: 200, SellPrice: 150, TradeVolume: 10},
+	}}
+
+	game.PlanTasks()
+	game.PlanTasks()
+	for kind, want := range map[TaskKind]int{TASK_MINE: 1, TASK_DELIVER: 1, TASK_REFUEL: 1, TASK_TRADE: 1, TASK_SCOUT: 1} {
+		if got := game.Tasks.Count(kind); got != want {
+			t.Errorf("%d %s tasks planned, want %d", got, kind, want)
+		}
+	}
+	for _, task := range game.Tasks.Tasks {
+		switch {
+		case task.Kind == TASK_MINE && task.Good != string(client.TradeSymbolICEWATER):
+			t.Errorf("mining for %q, want the contract good", task.Good)
+		case task.Kind == TASK_REFUEL && (task.For != hauler.Symbol || task.Waypoint != testStation):
+			t.Errorf("refueling %s at %s, want %s at %s", task.For, task.Waypoint, hauler.Symbol, testStation)
+		case task.Kind == TASK_SCOUT && task.Waypoint != testOutpost:
+			t.Errorf("scouting %s, want %s", task.Waypoint, testOutpost)
+		}
+	}
+}
+
+// shipClient answers GetMyShip with the ship as the server knows it.
+type shipClient struct {
+	client.ClientWithResponsesInterface
+	ship client.Ship
+}
+
+func (f *shipClient) GetMyShipWithResponse(ctx context.Context, shipSymbol string, reqEditors ...client.RequestEditorFn) (*client.GetMyShipResponse, error) {
+	return client.ParseGetMyShipResponse(apiResponse(200, f.ship))
+}
+
+func TestFailedShipReleasesTask(t *testing.T) {
+	tests := []struct {
+		name    string
+		cargo   client.ShipCargo
+		release bool
+	}{
+		{"without the goods", testCargo(0), true},
+		// the goods on board can only be delivered by this ship
+		{"with the goods", testCargo(20), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			game := dispatchGame(t)
+			failing := addShip(game, ROLE_HAULER, "TEST-1", testStation)
+			other := addShip(game, ROLE_HAULER, "TEST-2", testStation)
+			other.Cargo = client.ShipCargo{Capacity: 30, Units: 25, Inventory: []client.ShipCargoItem{}}
+			deliver := deliverTask()
+			game.Tasks.Add(deliver)
+			game.Dispatch()
+			if failing.Task != deliver {
+				t.Fatalf("%s has task %v, want %v", failing.Symbol, failing.Task, deliver)
+			}
+			deliver.Order.State = PROCURE_TRAVEL_MARKET
+
+			// the server has the failing ship drifting at the outpost
+			server := failing.Ship
+			server.Nav.WaypointSymbol = testOutpost
+			server.Cargo = test.cargo
+			game.Account.Client = &shipClient{ship: server}
+			game.Account.Events.Publish(ShipError{EventInfo: failing.event(), Action: "navigate", Err: errors.New("boom")})
+			game.ReconcileShips(false)
+			game.Dispatch()
+
+			if test.release {
+				if failing.Task != nil || other.Task != deliver || deliver.Ship != other.Symbol {
+					t.Errorf("task of the failed ship not handed to %s: tasks %v and %v", other.Symbol, failing.Task, other.Task)
+				}
+				if deliver.Order.State != "" {
+					t.Errorf("released order in %s, want it to start over", deliver.Order.State)
+				}
+			} else if failing.Task != deliver || other.Task != nil {
+				t.Errorf("task of the failed ship with the goods moved: tasks %v and %v", failing.Task, other.Task)
+			}
+		})
+	}
+}
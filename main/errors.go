@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Error codes returned by the SpaceTraders API that we act upon.
+const (
+	ErrCodeCooldown           = 4000
+	ErrCodeSurveyVerification = 4221
+	ErrCodeSurveyExpired      = 4222
+	ErrCodeSurveyExhausted    = 4224
+)
+
+// APIError is the error object the API returns in the body of a failed request.
+type APIError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Status  int             `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error %d (status %d): %s", e.Code, e.Status, e.Message)
+}
+
+// ParseAPIError decodes the error body of a failed request. If the body is not a
+// valid error object, the raw body is used as the message.
+func ParseAPIError(status int, body []byte) *APIError {
+	wrapper := struct {
+		Error APIError `json:"error"`
+	}{}
+	if err := json.Unmarshal(body, &wrapper); err != nil || wrapper.Error.Code == 0 {
+		return &APIError{Status: status, Message: string(body)}
+	}
+	wrapper.Error.Status = status
+	return &wrapper.Error
+}
+
+// IsCooldown reports whether the error means the ship's reactor is still cooling down.
+func (e *APIError) IsCooldown() bool {
+	return e.Code == ErrCodeCooldown
+}
+
+// IsSurveyUnusable reports whether the error means the survey used for an
+// extraction can no longer be used.
+func (e *APIError) IsSurveyUnusable() bool {
+	switch e.Code {
+	case ErrCodeSurveyVerification, ErrCodeSurveyExpired, ErrCodeSurveyExhausted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+// ContractChange says what happened to a contract in a ContractUpdated event.
+type ContractChange string
+
+const (
+	CONTRACT_ACCEPTED  ContractChange = "ACCEPTED"
+	CONTRACT_DELIVERED ContractChange = "DELIVERED"
+	CONTRACT_FULFILLED ContractChange = "FULFILLED"
+)
+
+// EventInfo is what every event has: when it happened, to which agent and, if
+// it was caused by a ship, to which ship.
+type EventInfo struct {
+	Time  time.Time
+	Agent string
+	Ship  string
+}
+
+func (info EventInfo) Info() EventInfo {
+	return info
+}
+
+// Event is something that happened in the game. Subscribers switch on the
+// concrete type to handle the events they are interested in.
+type Event interface {
+	Info() EventInfo
+}
+
+type ShipArrived struct {
+	EventInfo
+	Waypoint string
+}
+
+type CargoFull struct {
+	EventInfo
+	Waypoint string
+	Units    int
+}
+
+type SurveyCreated struct {
+	EventInfo
+	Waypoint string
+	Surveys  []client.Survey
+}
+
+type Extracted struct {
+	EventInfo
+	Waypoint string
+	Yield    client.ExtractionYield
+}
+
+// Sold and Bought carry the market transaction, with the role of the ship for the ledger.
+type Sold struct {
+	EventInfo
+	Role        string
+	Transaction client.MarketTransaction
+}
+
+type Bought struct {
+	EventInfo
+	Role        string
+	Transaction client.MarketTransaction
+}
+
+type Refueled struct {
+	EventInfo
+	Role     string
+	Waypoint string
+	Units    int
+	Cost     int
+}
+
+// ContractUpdated is published when a contract is accepted, fulfilled or cargo is
+// delivered to it. Payment is what we got paid for the change, if anything.
+type ContractUpdated struct {
+	EventInfo
+	Change   ContractChange
+	Contract client.Contract
+	Payment  int
+}
+
+type CooldownStarted struct {
+	EventInfo
+	Cooldown client.Cooldown
+}
+
+type MarketUpdated struct {
+	EventInfo
+	Market client.Market
+}
+
+// ShipError is published when an action of a ship fails without stopping the game.
+type ShipError struct {
+	EventInfo
+	Action string
+	Err    error
+}
+
+// ShipIdle is published when a ship without work waits until Until before
+// looking for work again. It is waiting on purpose, so it is not stuck.
+type ShipIdle struct {
+	EventInfo
+	Until time.Time
+}
+
+// CreditsChanged is published by the game loop when it sees the agent's credits change.
+type CreditsChanged struct {
+	EventInfo
+	Credits int
+}
+
+type ShipPurchased struct {
+	EventInfo
+	NewShip     client.Ship
+	Transaction client.ShipyardTransaction
+}
+
+// DescribeEvent returns a short description of the event for people, or an
+// empty string for events too frequent to be worth showing.
+func DescribeEvent(event Event) string {
+	switch event := event.(type) {
+	case ShipArrived:
+		return fmt.Sprintf("%s arrived at %s", event.Ship, event.Waypoint)
+	case CargoFull:
+		return fmt.Sprintf("%s is full with %d units at %s", event.Ship, event.Units, event.Waypoint)
+	case SurveyCreated:
+		return fmt.Sprintf("%s created %d surveys at %s", event.Ship, len(event.Surveys), event.Waypoint)
+	case Extracted:
+		return fmt.Sprintf("%s extracted %d %s", event.Ship, event.Yield.Units, event.Yield.Symbol)
+	case Sold:
+		return fmt.Sprintf("%s sold %d %s for %d", event.Ship, event.Transaction.Units, event.Transaction.TradeSymbol, event.Transaction.TotalPrice)
+	case Bought:
+		return fmt.Sprintf("%s bought %d %s for %d", event.Ship, event.Transaction.Units, event.Transaction.TradeSymbol, event.Transaction.TotalPrice)
+	case Refueled:
+		return fmt.Sprintf("%s refueled %d for %d", event.Ship, event.Units, event.Cost)
+	case ContractUpdated:
+		if event.Payment > 0 {
+			return fmt.Sprintf("Contract %s %s, paid %d", event.Contract.Id, strings.ToLower(string(event.Change)), event.Payment)
+		}
+		return fmt.Sprintf("Contract %s %s", event.Contract.Id, strings.ToLower(string(event.Change)))
+	case ShipError:
+		return fmt.Sprintf("%s failed to %s: %v", event.Ship, event.Action, event.Err)
+	case ShipPurchased:
+		return fmt.Sprintf("Bought ship %s for %d", event.NewShip.Symbol, event.Transaction.Price)
+	}
+	return ""
+}
+
+// EventBus delivers events to the subscribers of an agent. Events are handled
+// synchronously in the goroutine that publishes them, in the order the
+// subscribers subscribed, so handlers must be quick.
+type EventBus struct {
+	mu       sync.RWMutex
+	handlers []func(Event)
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+func (bus *EventBus) Subscribe(handler func(Event)) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.handlers = append(bus.handlers, handler)
+}
+
+func (bus *EventBus) Publish(event Event) {
+	bus.mu.RLock()
+	handlers := bus.handlers
+	bus.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// event returns the info for an event caused by the ship.
+func (ship *Ship) event() EventInfo {
+	return EventInfo{Time: ship.Clock.Now(), Agent: ship.Account.Name, Ship: ship.Symbol}
+}
+
+// event returns the info for an event caused by the game rather than a ship.
+func (game *Game) event() EventInfo {
+	return EventInfo{Time: game.Clock.Now(), Agent: game.Account.Name}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+const (
+	FLEET_CHECK_INTERVAL   = 5 * time.Minute
+	DEFAULT_CREDIT_RESERVE = 50000
+	// EXTRACTIONS_PER_HOUR is a rough estimate of how often a miner can extract, given
+	// the reactor cooldown and the trips to the market
+	EXTRACTIONS_PER_HOUR = 40
+)
+
+// UpdateShipyards fetches every known shipyard we have not seen yet, and every
+// shipyard one of our ships is at, since only then the prices are visible. It
+// returns the waypoints our ships are at.
+func (game *Game) UpdateShipyards() map[string]bool {
+	present := make(map[string]bool)
+	for _, ship := range game.State.Ships {
+		if ship.Status() != client.INTRANSIT {
+			present[ship.Location()] = true
+		}
+	}
+	for symbol, wp := range game.State.Waypoints {
+		if !wp.HasShipyard() {
+			continue
+		}
+		if _, known := game.State.Shipyards[symbol]; known && !present[symbol] {
+			continue
+		}
+		resp, err := game.Account.Client.GetShipyardWithResponse(context.TODO(), wp.SystemSymbol, symbol)
+		if err != nil {
+			game.Log().Error("Failed to fetch shipyard", "waypoint", symbol, "error", err)
+			continue
+		}
+		if resp.StatusCode() != 200 {
+			game.Log().Error("Failed to fetch shipyard", "waypoint", symbol, "error", ParseAPIError(resp.StatusCode(), resp.Body))
+			continue
+		}
+		game.State.UpdateShipyard(resp.JSON200.Data)
+	}
+	return present
+}
+
+// UpdateShipyard stores the shipyard, keeping the last known prices if the new
+// data does not include them.
+func (state *State) UpdateShipyard(shipyard client.Shipyard) {
+	if state.Shipyards == nil {
+		state.Shipyards = make(map[string]client.Shipyard)
+	}
+	if old, ok := state.Shipyards[shipyard.Symbol]; ok && shipyard.Ships == nil {
+		shipyard.Ships = old.Ships
+	}
+	state.Shipyards[shipyard.Symbol] = shipyard
+}
+
+// AverageOreValue returns the average best sell price of the goods found in our
+// surveys, as an estimate of what a unit of mined ore is worth.
+func (state *State) AverageOreValue() float64 {
+	seen := make(map[string]bool)
+	total := 0
+	for _, surveys := range state.Surveys {
+		for _, survey := range surveys {
+			for _, dep := range survey.Deposits {
+				if !seen[dep.Symbol] {
+					seen[dep.Symbol] = true
+					total += state.BestSellPrice(dep.Symbol)
+				}
+			}
+		}
+	}
+	if len(seen) == 0 || total == 0 {
+		return 1
+	}
+	return float64(total) / float64(len(seen))
+}
+
+// EstimateShipIncome estimates the credits per hour a ship of this type earns.
+// Only mining is taken into account, so ships without mining lasers earn nothing.
+func (state *State) EstimateShipIncome(ship client.ShipyardShip) float64 {
+	return float64(ship.MiningStrength()) * EXTRACTIONS_PER_HOUR * state.AverageOreValue()
+}
+
+// PlanFleet buys the ship that pays for itself the fastest, as long as we keep
+// the credit reserve after buying it. Ships can only be bought at a shipyard
+// one of our ships is at. Only mining income is estimated, so it only buys
+// miners; haulers and probes are never chosen.
+func (game *Game) PlanFleet() {
+	present := game.UpdateShipyards()
+	budget := game.State.Agent.Credits - game.Account.Strategy.CreditReserve
+	if budget <= 0 {
+		return
+	}
+	var best *client.ShipyardShip
+	bestShipyard := ""
+	bestRate := 0.0
+	for symbol, shipyard := range game.State.Shipyards {
+		if shipyard.Ships == nil || !present[symbol] {
+			continue
+		}
+		for i, ship := range *shipyard.Ships {
+			if ship.Type == nil || ship.PurchasePrice <= 0 || ship.PurchasePrice > budget {
+				continue
+			}
+			rate := game.State.EstimateShipIncome(ship) / float64(ship.PurchasePrice)
+			if rate > bestRate {
+				best = &(*shipyard.Ships)[i]
+				bestShipyard = symbol
+				bestRate = rate
+			}
+		}
+	}
+	if best == nil {
+		return
+	}
+	game.Log().Info("Buying ship", "type", *best.Type, "waypoint", bestShipyard, "price", best.PurchasePrice, "income_per_hour", int(game.State.EstimateShipIncome(*best)))
+	if err := game.PurchaseShip(*best.Type, bestShipyard); err != nil {
+		game.Log().Error("Failed to buy ship", "type", *best.Type, "waypoint", bestShipyard, "error", err)
+	}
+}
+
+// PurchaseShip buys a ship and puts it to work with the role it is registered for.
+func (game *Game) PurchaseShip(shipType client.ShipType, waypointSymbol string) error {
+	resp, err := game.Account.Client.PurchaseShipWithResponse(context.TODO(), client.PurchaseShipJSONRequestBody{
+		ShipType:       shipType,
+		WaypointSymbol: waypointSymbol,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != 201 {
+		return ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	data := resp.JSON201.Data
+	game.State.Agent = data.Agent
+	game.State.Ships = append(game.State.Ships, NewShipForRole(data.Ship, game.Account, game.Clock))
+	game.Account.Events.Publish(ShipPurchased{EventInfo: game.event(), NewShip: data.Ship, Transaction: data.Transaction})
+	game.Log().Info("Bought ship", "ship", data.Ship.Symbol, "role", data.Ship.Registration.Role, "price", data.Transaction.Price, "credits", data.Agent.Credits)
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+// shipyardClient serves the shipyards and records the ships bought.
+type shipyardClient struct {
+	client.ClientWithResponsesInterface
+	shipyards map[string]client.Shipyard
+	bought    []string
+}
+
+func (f *shipyardClient) GetShipyardWithResponse(ctx context.Context, systemSymbol string, waypointSymbol string, reqEditors ...client.RequestEditorFn) (*client.GetShipyardResponse, error) {
+	return client.ParseGetShipyardResponse(apiResponse(200, f.shipyards[waypointSymbol]))
+}
+
+func (f *shipyardClient) PurchaseShipWithResponse(ctx context.Context, body client.PurchaseShipJSONRequestBody, reqEditors ...client.RequestEditorFn) (*client.PurchaseShipResponse, error) {
+	f.bought = append(f.bought, body.WaypointSymbol)
+	return client.ParsePurchaseShipResponse(apiResponse(201, map[string]any{
+		"agent":       client.Agent{Credits: 100000},
+		"ship":        client.Ship{Symbol: "TEST-2"},
+		"transaction": client.ShipyardTransaction{WaypointSymbol: body.WaypointSymbol},
+	}))
+}
+
+func testShipyard(symbol string, price int) client.Shipyard {
+	shipType := client.SHIPOREHOUND
+	strength := 10
+	ships := []client.ShipyardShip{{Type: &shipType, PurchasePrice: price,
+		Mounts: []client.ShipMount{{Symbol: client.MOUNTMININGLASERI, Strength: &strength}}}}
+	return client.Shipyard{Symbol: symbol, Ships: &ships}
+}
+
+func TestPlanFleetBuysWhereAShipIs(t *testing.T) {
+	const (
+		near = "X1-TEST-S1"
+		far  = "X1-TEST-S2"
+	)
+	tests := []struct {
+		name     string
+		location string
+		bought   []string
+	}{
+		// the far shipyard is cheaper, but the ship can only be bought where we are
+		{"ship at the dearer shipyard", near, []string{near}},
+		{"ship at the cheaper shipyard", far, []string{far}},
+		{"ship at no shipyard", testStation, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := DefaultStrategy()
+			account := NewAccount(AgentConfig{Name: "test", Rate: 1000, Burst: 100}, &strategy)
+			account.StatePath = filepath.Join(t.TempDir(), "game.state.json")
+			fake := &shipyardClient{shipyards: map[string]client.Shipyard{
+				near: testShipyard(near, 100000),
+				far:  testShipyard(far, 50000),
+			}}
+			account.Client = fake
+			game := NewGame(account)
+			game.State.Agent.Credits = 200000
+			for symbol := range fake.shipyards {
+				game.State.Waypoints[symbol] = client.ScannedWaypoint{Symbol: symbol, SystemSymbol: testSystem,
+					Traits: []client.WaypointTrait{{Symbol: client.WaypointTraitSymbolSHIPYARD}}}
+				game.State.UpdateShipyard(fake.shipyards[symbol])
+			}
+			ship := client.Ship{Symbol: "TEST-1", Nav: client.ShipNav{WaypointSymbol: tt.location, Status: client.DOCKED}}
+			game.State.Ships = []BaseShip{NewShipForRole(ship, account, game.Clock)}
+
+			game.PlanFleet()
+			if !reflect.DeepEqual(fake.bought, tt.bought) {
+				t.Errorf("bought at %v, want %v", fake.bought, tt.bought)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+// Package fsm declares state machines as data: the states, the transitions
+// between them, the guards that choose a transition and the actions performed
+// on the way. The same declaration runs the machine and draws it, so the graph
+// always matches what the ships do.
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Guard is a named condition on the context. It must not have side effects, so
+// a decision can be checked without acting on it.
+type Guard[C any] struct {
+	Name  string
+	Check func(c C) bool
+}
+
+// Action is a named side effect performed during a transition.
+type Action[C any] struct {
+	Name string
+	Do   func(c C) error
+}
+
+// Transition leads from one state to another when its guard holds. A nil guard
+// always holds. An empty To state lets the machine's Initial function derive
+// the next state once the actions are done.
+type Transition[S ~string, C any] struct {
+	From    S
+	To      S
+	Guard   *Guard[C]
+	Actions []Action[C]
+	// OnError is the state to move to when an action fails, the remaining
+	// actions are skipped. Without it the machine moves to To regardless.
+	OnError S
+}
+
+// Machine is a set of transitions. The transitions out of a state are tried in
+// the order they were declared and the first whose guard holds is taken.
+type Machine[S ~string, C any] struct {
+	Name string
+	// Initial derives the state from the context, when there is none yet or a
+	// transition has no target
+	Initial     func(c C) S
+	states      []S
+	transitions map[S][]Transition[S, C]
+	// last is the state the transition declared last leads from
+	last S
+}
+
+func New[S ~string, C any](name string, initial func(c C) S) *Machine[S, C] {
+	return &Machine[S, C]{Name: name, Initial: initial, transitions: map[S][]Transition[S, C]{}}
+}
+
+// Add declares a transition after the ones already declared from its state.
+func (m *Machine[S, C]) Add(t Transition[S, C]) *Machine[S, C] {
+	if _, ok := m.transitions[t.From]; !ok {
+		m.states = append(m.states, t.From)
+	}
+	m.transitions[t.From] = append(m.transitions[t.From], t)
+	m.last = t.From
+	return m
+}
+
+// When declares a transition that is taken when the guard holds.
+func (m *Machine[S, C]) When(from S, guard *Guard[C], to S, actions ...Action[C]) *Machine[S, C] {
+	return m.Add(Transition[S, C]{From: from, To: to, Guard: guard, Actions: actions})
+}
+
+// Always declares a transition without a guard, taken when no transition
+// declared earlier from the same state is.
+func (m *Machine[S, C]) Always(from S, to S, actions ...Action[C]) *Machine[S, C] {
+	return m.Add(Transition[S, C]{From: from, To: to, Actions: actions})
+}
+
+// OnError sets the state the transition declared last moves to when one of its
+// actions fails.
+func (m *Machine[S, C]) OnError(state S) *Machine[S, C] {
+	transitions := m.transitions[m.last]
+	transitions[len(transitions)-1].OnError = state
+	return m
+}
+
+// States returns the states with transitions out of them, in declaration order.
+func (m *Machine[S, C]) States() []S {
+	return m.states
+}
+
+// Decide returns the transition the machine takes from the state. It only
+// evaluates guards.
+func (m *Machine[S, C]) Decide(state S, c C) (Transition[S, C], bool) {
+	for _, t := range m.transitions[state] {
+		if t.Guard == nil || t.Guard.Check(c) {
+			return t, true
+		}
+	}
+	return Transition[S, C]{}, false
+}
+
+// Step takes a single transition from the state and returns the state the
+// machine is in afterwards. A state without a transition that can be taken
+// falls back to the Initial state.
+func (m *Machine[S, C]) Step(state S, c C) S {
+	if state == "" {
+		state = m.Initial(c)
+	}
+	t, ok := m.Decide(state, c)
+	if !ok {
+		return m.Initial(c)
+	}
+	next := t.To
+	for _, action := range t.Actions {
+		if err := action.Do(c); err != nil {
+			if t.OnError != "" {
+				next = t.OnError
+			}
+			break
+		}
+	}
+	if next == "" {
+		return m.Initial(c)
+	}
+	return next
+}
+
+// Validate checks that every state a transition leads to has transitions out
+// of it and that no transition can never be taken because an unguarded one
+// from the same state comes first.
+func (m *Machine[S, C]) Validate() error {
+	errs := []error{}
+	for _, from := range m.states {
+		for i, t := range m.transitions[from] {
+			for _, to := range []S{t.To, t.OnError} {
+				if _, ok := m.transitions[to]; to != "" && !ok {
+					errs = append(errs, fmt.Errorf("%s: %s leads to %s, which has no transitions", m.Name, from, to))
+				}
+			}
+			if t.Guard == nil && i < len(m.transitions[from])-1 {
+				errs = append(errs, fmt.Errorf("%s: transitions from %s after the unguarded one to %s are never taken", m.Name, from, t.To))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DOT draws the machine as a Graphviz graph. Edges are labelled with their
+// guard and actions, transitions without a target lead to an (initial) node
+// and failed actions are dashed edges.
+func (m *Machine[S, C]) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", m.Name)
+	b.WriteString("\tnode [shape=box, style=rounded];\n")
+	b.WriteString("\t\"(initial)\" [shape=ellipse];\n")
+	node := func(state S) string {
+		if state == "" {
+			return `"(initial)"`
+		}
+		return fmt.Sprintf("%q", string(state))
+	}
+	for _, from := range m.states {
+		for i, t := range m.transitions[from] {
+			label := ""
+			if len(m.transitions[from]) > 1 {
+				label = fmt.Sprintf("%d. ", i+1)
+			}
+			if t.Guard != nil {
+				label += "[" + t.Guard.Name + "]"
+			} else if len(m.transitions[from]) > 1 {
+				label += "[else]"
+			}
+			names := []string{}
+			for _, action := range t.Actions {
+				names = append(names, action.Name)
+			}
+			if len(names) > 0 {
+				label += " / " + strings.Join(names, ", ")
+			}
+			fmt.Fprintf(&b, "\t%s -> %s [label=%q];\n", node(from), node(t.To), strings.TrimSpace(label))
+			if t.OnError != "" {
+				fmt.Fprintf(&b, "\t%s -> %s [label=%q, style=dashed];\n", node(from), node(t.OnError), "error")
+			}
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,170 @@
+package fsm
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type state string
+
+// counter is the context of the test machines: a number to guard on and a log
+// of the actions performed.
+type counter struct {
+	n   int
+	log *[]string
+}
+
+func record(name string, err error) Action[counter] {
+	return Action[counter]{Name: name, Do: func(c counter) error {
+		*c.log = append(*c.log, name)
+		return err
+	}}
+}
+
+var (
+	positive = &Guard[counter]{Name: "positive", Check: func(c counter) bool { return c.n > 0 }}
+	large    = &Guard[counter]{Name: "large", Check: func(c counter) bool { return c.n > 10 }}
+)
+
+func TestDecideTakesTheFirstGuardThatHolds(t *testing.T) {
+	m := New("test", func(c counter) state { return "START" }).
+		When("START", positive, "POSITIVE").
+		When("START", large, "LARGE").
+		Always("START", "OTHER")
+	tests := []struct {
+		n    int
+		want state
+	}{
+		{n: 5, want: "POSITIVE"},
+		// large also holds, but positive was declared first
+		{n: 50, want: "POSITIVE"},
+		{n: -1, want: "OTHER"},
+	}
+	for _, test := range tests {
+		transition, ok := m.Decide("START", counter{n: test.n})
+		if !ok || transition.To != test.want {
+			t.Errorf("Decide with n=%d = %s, %v, want %s", test.n, transition.To, ok, test.want)
+		}
+	}
+	if _, ok := m.Decide("UNKNOWN", counter{}); ok {
+		t.Errorf("Decide from a state without transitions found one")
+	}
+}
+
+func TestStep(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name    string
+		machine *Machine[state, counter]
+		from    state
+		want    state
+		log     []string
+	}{
+		{
+			name:    "always runs the actions in order",
+			machine: New("test", func(c counter) state { return "INITIAL" }).Always("A", "B", record("first", nil), record("second", nil)),
+			from:    "A",
+			want:    "B",
+			log:     []string{"first", "second"},
+		},
+		{
+			name: "on error skips the remaining actions",
+			machine: New("test", func(c counter) state { return "INITIAL" }).
+				Add(Transition[state, counter]{From: "A", To: "B", Actions: []Action[counter]{record("first", boom), record("second", nil)}, OnError: "FAILED"}),
+			from: "A",
+			want: "FAILED",
+			log:  []string{"first"},
+		},
+		{
+			name:    "on error declared after the transition",
+			machine: New("test", func(c counter) state { return "INITIAL" }).Always("A", "B", record("first", boom)).OnError("FAILED"),
+			from:    "A",
+			want:    "FAILED",
+			log:     []string{"first"},
+		},
+		{
+			name:    "without on error a failure still leads to the target",
+			machine: New("test", func(c counter) state { return "INITIAL" }).Always("A", "B", record("first", boom), record("second", nil)),
+			from:    "A",
+			want:    "B",
+			log:     []string{"first"},
+		},
+		{
+			name:    "an empty target derives the state",
+			machine: New("test", func(c counter) state { return "INITIAL" }).Always("A", "", record("first", nil)),
+			from:    "A",
+			want:    "INITIAL",
+			log:     []string{"first"},
+		},
+		{
+			name:    "no state starts from the initial one",
+			machine: New("test", func(c counter) state { return "A" }).Always("A", "B"),
+			from:    "",
+			want:    "B",
+		},
+		{
+			name:    "no transition falls back to the initial state",
+			machine: New("test", func(c counter) state { return "INITIAL" }).When("A", positive, "B"),
+			from:    "A",
+			want:    "INITIAL",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := []string{}
+			if got := test.machine.Step(test.from, counter{log: &log}); got != test.want {
+				t.Errorf("Step = %s, want %s", got, test.want)
+			}
+			if len(log) > 0 || len(test.log) > 0 {
+				if !reflect.DeepEqual(log, test.log) {
+					t.Errorf("actions %v, want %v", log, test.log)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := New("valid", func(c counter) state { return "A" }).
+		When("A", positive, "B").
+		Always("A", "A").
+		Add(Transition[state, counter]{From: "B", To: "A", OnError: "B"})
+	if err := valid.Validate(); err != nil {
+		t.Errorf("valid machine: %v", err)
+	}
+
+	invalid := New("invalid", func(c counter) state { return "A" }).
+		Always("A", "B").
+		When("A", positive, "A").
+		Add(Transition[state, counter]{From: "B", To: "A", OnError: "NOWHERE"})
+	err := invalid.Validate()
+	if err == nil {
+		t.Fatal("invalid machine validated")
+	}
+	for _, want := range []string{"NOWHERE, which has no transitions", "after the unguarded one to B are never taken"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestDOT(t *testing.T) {
+	m := New("test", func(c counter) state { return "A" }).
+		When("A", positive, "B", record("count", nil)).
+		Always("A", "A").
+		Add(Transition[state, counter]{From: "B", Actions: []Action[counter]{record("reset", nil)}, OnError: "A"})
+	want := `digraph "test" {
+	node [shape=box, style=rounded];
+	"(initial)" [shape=ellipse];
+	"A" -> "B" [label="1. [positive] / count"];
+	"A" -> "A" [label="2. [else]"];
+	"B" -> "(initial)" [label="/ reset"];
+	"B" -> "A" [label="error", style=dashed];
+}
+`
+	if got := m.DOT(); got != want {
+		t.Errorf("DOT =\n%s\nwant\n%s", got, want)
+	}
+}
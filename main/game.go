@@ -3,18 +3,37 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"os"
 	"time"
 
 	"github.com/Dutchy-/spacetrader-go/client"
+	"github.com/Dutchy-/spacetrader-go/main/clock"
 )
 
 const STATE_FILE = "game.state.json"
 
 type Game struct {
-	// Client *client.ClientWithResponses
-	State State `json:"state"`
+	// Account is the agent the game is played by
+	Account *Account    `json:"-"`
+	State   State       `json:"state"`
+	Clock   clock.Clock `json:"-"`
+	// Tasks is the queue of work the ships are dispatched to
+	Tasks *Dispatcher `json:"-"`
+	// replan is set by events that make the contract and procurement plans outdated
+	replan bool
+	// reconcile maps ships to the action that failed since they were last
+	// compared with the server
+	reconcile map[string]string
+	// declined are the contract offers we decided not to take
+	declined map[string]bool
+	// the times of the periodic checks of the game loop, and the credits last
+	// published
+	lastContractCheck time.Time `json:"-"`
+	lastFleetCheck    time.Time `json:"-"`
+	lastReconcile     time.Time `json:"-"`
+	lastSave          time.Time `json:"-"`
+	lastCredits       int       `json:"-"`
 }
 
 type State struct {
@@ -25,11 +44,18 @@ type State struct {
 	WaypointsBySystem map[string][]client.ScannedWaypoint `json:"waypoints_by_system"`
 	Waypoints         map[string]client.ScannedWaypoint   `json:"waypoints"`
 	Markets           map[string]client.Market            `json:"markets"`
+	Shipyards         map[string]client.Shipyard          `json:"shipyards"`
+	// Cooldowns are the reactor cooldowns of the ships, so they survive a restart
+	Cooldowns map[string]client.Cooldown `json:"cooldowns"`
+	// Surveying maps a waypoint to the claim of the ship currently surveying it
+	Surveying map[string]SurveyClaim `json:"-"`
+	Clock     clock.Clock            `json:"-"`
 }
 
-func NewGame() *Game {
-	game := Game{}
-	b, err := os.ReadFile(STATE_FILE)
+func NewGame(account *Account) *Game {
+	game := Game{Account: account, Clock: clock.Real{}, Tasks: NewDispatcher(), reconcile: map[string]string{}, declined: map[string]bool{}}
+	game.State.Clock = game.Clock
+	b, err := os.ReadFile(account.StatePath)
 	if err == nil {
 		err = json.Unmarshal(b, &game)
 		if err != nil {
@@ -38,72 +64,47 @@ func NewGame() *Game {
 		if game.State.WaypointsBySystem == nil {
 			game.State.WaypointsBySystem = make(map[string][]client.ScannedWaypoint)
 		}
+		if game.State.Cooldowns == nil {
+			game.State.Cooldowns = make(map[string]client.Cooldown)
+		}
 	} else {
 		game.State.Surveys = make(map[string][]client.Survey)
 		game.State.WaypointsBySystem = make(map[string][]client.ScannedWaypoint)
 		game.State.Waypoints = make(map[string]client.ScannedWaypoint)
 		game.State.Markets = make(map[string]client.Market)
+		game.State.Cooldowns = make(map[string]client.Cooldown)
 	}
 	return &game
 }
 
-func (game *Game) Run() {
+// Run plays the game until it fails to start.
+func (game *Game) Run() error {
+	game.Account.Events.Subscribe(game.Handle)
 
-	game.InitAgent()
+	if err := game.InitAgent(); err != nil {
+		return err
+	}
 	game.InitContracts()
 
 	// fmt.Println("Agent: ", agent.JSON200.Data.Symbol, agent.JSON200.Data.AccountId, agent.JSON200.Data.Credits, agent.JSON200.Data.Headquarters)
 
-	game.InitShips()
-
-	if !game.State.Contracts[0].Accepted {
-		resp, err := Client.AcceptContractWithResponse(context.TODO(), game.State.Contracts[0].Id)
-		if err != nil {
-			panic(err)
-		}
-		if resp.StatusCode() != 200 {
-			panic(string(resp.Body))
-		}
-		data := resp.JSON200.Data
-		game.State.Agent = data.Agent
-		game.State.Contracts[0] = data.Contract
+	if err := game.InitShips(); err != nil {
+		return err
 	}
 
-	// assign contract to ship, single ship for now
-	m, _ := game.State.Ships[0].(*Miner)
-	m.Contract = game.State.Contracts[0]
+	game.ManageContracts()
+	game.lastContractCheck = game.Clock.Now()
+	game.PlanFleet()
+	game.lastFleetCheck = game.Clock.Now()
+	game.lastReconcile = game.Clock.Now()
+	if err := game.Save(); err != nil {
+		game.Log().Error("Failed to save the state", "error", err)
+	}
+	game.lastSave = game.Clock.Now()
 
-	// background-save every 10 seconds
-	go func() {
-		for {
-			b, err := json.MarshalIndent(*game, "", "  ")
-			if err != nil {
-				panic(err)
-			}
-			err = os.WriteFile(STATE_FILE, b, 0644)
-			if err != nil {
-				panic(err)
-			}
-			time.Sleep(10 * time.Second)
-		}
-	}()
 	// main game loop
 	for {
-		allOnCooldown := true
-		for _, ship := range game.State.Ships {
-			cooldown := ship.GetCooldown()
-			if (cooldown == client.Cooldown{} || cooldown.Expiration.Before(time.Now())) {
-				allOnCooldown = false
-				switch ship := ship.(type) {
-				case *Miner:
-					ship.Run(&game.State)
-				}
-			}
-		}
-		if allOnCooldown {
-			// fmt.Println("All ships on cooldown, waiting...")
-			time.Sleep(500 * time.Millisecond)
-		}
+		game.Step()
 	}
 
 	// limit := 20
@@ -121,32 +122,182 @@ func (game *Game) Run() {
 	// fmt.Println("Current System: ", system.JSON200.Data.Symbol, system.JSON200.Data.SectorSymbol, system.JSON200.Data.Factions)
 }
 
-func (game *Game) InitShips() {
-	log.Println("Initialising Ships...")
+// Step runs one iteration of the game loop: it does the periodic checks, plans
+// and dispatches the tasks, and runs every ship that is ready. If no ship is
+// ready it sleeps for the poll interval.
+func (game *Game) Step() {
+	game.ApplyStrategy()
+	// saved here rather than in the background, as the state is only safe
+	// to read from the game loop
+	if game.Clock.Now().Sub(game.lastSave) > game.Account.Strategy.SaveInterval.Duration {
+		if err := game.Save(); err != nil {
+			game.Log().Error("Failed to save the state", "error", err)
+		}
+		game.lastSave = game.Clock.Now()
+	}
+	if credits := game.State.Agent.Credits; credits != game.lastCredits {
+		game.Account.Events.Publish(CreditsChanged{EventInfo: game.event(), Credits: credits})
+		game.lastCredits = credits
+	}
+	game.State.PruneSurveys()
+	Stats.ObserveState(game.Account.Name, &game.State)
+	Board.Update(game.Account.Name, &game.State)
+	if Screen != nil {
+		Screen.Update(game.Account.Name, &game.State)
+	}
+	if game.Clock.Now().Sub(game.lastContractCheck) > game.Account.Strategy.ContractCheckInterval.Duration {
+		game.FetchContracts()
+		game.ManageContracts()
+		game.lastContractCheck = game.Clock.Now()
+		game.replan = false
+	} else if game.replan {
+		game.ManageContracts()
+		game.replan = false
+	}
+	if game.Clock.Now().Sub(game.lastFleetCheck) > game.Account.Strategy.FleetCheckInterval.Duration {
+		game.PlanFleet()
+		game.lastFleetCheck = game.Clock.Now()
+	}
+	if game.Clock.Now().Sub(game.lastReconcile) > game.Account.Strategy.ReconcileInterval.Duration {
+		game.ReconcileShips(true)
+		game.lastReconcile = game.Clock.Now()
+	} else if len(game.reconcile) > 0 {
+		game.ReconcileShips(false)
+	}
+	game.PlanTasks()
+	game.Dispatch()
+	allOnCooldown := true
+	for _, ship := range game.State.Ships {
+		if !ship.ReadyAt().After(game.Clock.Now()) {
+			allOnCooldown = false
+			switch ship := ship.(type) {
+			case *Miner:
+				ship.Run(&game.State)
+			case *Hauler:
+				ship.Run(&game.State)
+			}
+		}
+	}
+	if allOnCooldown {
+		// fmt.Println("All ships on cooldown, waiting...")
+		game.Clock.Sleep(game.Account.Strategy.PollInterval.Duration)
+	}
+}
+
+// Save writes the game to the state file.
+func (game *Game) Save() error {
+	b, err := json.MarshalIndent(*game, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(game.Account.StatePath, b, 0644)
+}
+
+func (game *Game) InitShips() error {
+	game.Log().Info("Initialising Ships...")
 	game.State.Ships = make([]BaseShip, 0)
-	ships, _ := Client.GetMyShipsWithResponse(context.TODO(), &client.GetMyShipsParams{})
+	ships, err := game.Account.Client.GetMyShipsWithResponse(context.TODO(), &client.GetMyShipsParams{})
+	if err != nil {
+		return err
+	}
+	if ships.StatusCode() != 200 {
+		return ParseAPIError(ships.StatusCode(), ships.Body)
+	}
 	for _, ship := range ships.JSON200.Data {
-		game.State.Ships = append(game.State.Ships, &Miner{Ship: Ship{Ship: ship}})
+		game.State.Ships = append(game.State.Ships, NewShipForRole(ship, game.Account, game.Clock))
+	}
+	game.InitCooldowns()
+	return nil
+}
+
+// InitCooldowns restores the saved reactor cooldowns and then asks the API for
+// the current ones, so ships do not act while their reactor is still cooling
+// down from before a restart.
+func (game *Game) InitCooldowns() {
+	now := game.Clock.Now()
+	for symbol, cooldown := range game.State.Cooldowns {
+		if !cooldown.Expiration.After(now) {
+			delete(game.State.Cooldowns, symbol)
+		}
+	}
+	for _, ship := range game.State.Ships {
+		s := baseShip(ship)
+		if cooldown, ok := game.State.Cooldowns[s.Symbol]; ok {
+			s.Cooldown = cooldown
+		}
+		if err := s.FetchCooldown(); err != nil {
+			game.Log().Error("Failed to fetch cooldown, using the saved one", "ship", s.Symbol, "error", err)
+		} else if s.Cooldown == (client.Cooldown{}) {
+			delete(game.State.Cooldowns, s.Symbol)
+		}
+	}
+}
+
+// NewShipForRole wraps the ship in the type that matches the role it is assigned
+// in the strategy, or else its registered role.
+func NewShipForRole(ship client.Ship, account *Account, clock clock.Clock) BaseShip {
+	switch account.Strategy.ShipRole(ship) {
+	case ROLE_HAULER:
+		return &Hauler{Ship: Ship{Ship: ship, Clock: clock, Account: account}}
+	default:
+		return &Miner{Ship: Ship{Ship: ship, Clock: clock, Account: account}}
+	}
+}
+
+// Handle plans again after deliveries, which may complete a contract, and after
+// market updates, which may make buying goods for the contract worthwhile. It
+// also keeps the reactor cooldowns for the state file and remembers ships
+// that failed an action, to compare them with the server.
+func (game *Game) Handle(event Event) {
+	switch event := event.(type) {
+	case CooldownStarted:
+		game.State.Cooldowns[event.Ship] = event.Cooldown
+	case ShipError:
+		game.reconcile[event.Ship] = event.Action
+		// a failed ship must not keep other miners from surveying
+		game.State.ReleaseAllSurveying(event.Ship)
+	case ContractUpdated:
+		if event.Change == CONTRACT_DELIVERED {
+			game.replan = true
+		}
+	case MarketUpdated:
+		game.replan = true
+	}
+}
+
+// Log returns a logger with the agent as a field.
+func (game *Game) Log() *slog.Logger {
+	return slog.With("agent", game.Account.Name)
+}
+
+// SetClock makes the game, its state and all ships use the clock.
+func (game *Game) SetClock(clock clock.Clock) {
+	game.Clock = clock
+	game.State.Clock = clock
+	for _, ship := range game.State.Ships {
+		ship.SetClock(clock)
 	}
 }
 
 func (game *Game) InitContracts() {
-	log.Println("Initialising Contracts...")
-	contracts, _ := Client.GetContractsWithResponse(context.TODO(), &client.GetContractsParams{})
-	game.State.Contracts = contracts.JSON200.Data
-	Pprint(game.State.Contracts)
+	game.Log().Info("Initialising Contracts...")
+	game.FetchContracts()
+	for _, contract := range game.State.Contracts {
+		game.Log().Info("Contract", "contract", contract.Id, "type", contract.Type, "accepted", contract.Accepted, "fulfilled", contract.Fulfilled, "deadline", contract.Terms.Deadline)
+	}
 }
 
-func (game *Game) InitAgent() {
-	log.Println("Initialising Agent...")
-	agent, err := Client.GetMyAgentWithResponse(context.TODO())
+func (game *Game) InitAgent() error {
+	game.Log().Info("Initialising Agent...")
+	agent, err := game.Account.Client.GetMyAgentWithResponse(context.TODO())
 	if err != nil {
-		panic(err)
+		return err
 	}
 	if agent.StatusCode() != 200 {
-		panic(string(agent.Body))
+		return ParseAPIError(agent.StatusCode(), agent.Body)
 	}
 	game.State.Agent = agent.JSON200.Data
+	return nil
 }
 
 func (state *State) AddSurveys(waypointSymbol string, surveys []client.Survey) {
@@ -169,16 +320,29 @@ func (state *State) GetAsteroid(systemSymbol string) *client.ScannedWaypoint {
 	return nil
 }
 
-func (state *State) GetOreSurvey(waypointSymbol string, oreType string) *client.Survey {
-	for _, survey := range state.Surveys[waypointSymbol] {
-		if survey.Expiration.After(time.Now()) {
-			for _, dep := range survey.Deposits {
-				if dep.Symbol == oreType {
-					return &survey
-				}
+// BestSellPrice returns the highest known price at which a good can be sold in any
+// of the markets we have seen, or 0 if we do not know a price.
+func (state *State) BestSellPrice(good string) int {
+	best := 0
+	for _, market := range state.Markets {
+		if market.TradeGoods == nil {
+			continue
+		}
+		for _, tg := range *market.TradeGoods {
+			if tg.Symbol == good && tg.SellPrice > best {
+				best = tg.SellPrice
 			}
 		}
 	}
+	return best
+}
+
+func (state *State) GetContract(id string) *client.Contract {
+	for i, c := range state.Contracts {
+		if c.Id == id {
+			return &state.Contracts[i]
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+// shipsFailClient fails to list the ships.
+type shipsFailClient struct {
+	client.ClientWithResponsesInterface
+}
+
+func (shipsFailClient) GetMyShipsWithResponse(ctx context.Context, params *client.GetMyShipsParams, reqEditors ...client.RequestEditorFn) (*client.GetMyShipsResponse, error) {
+	body := []byte(`{"error":{"code":500,"message":"Internal server error"}}`)
+	return &client.GetMyShipsResponse{Body: body, HTTPResponse: &http.Response{StatusCode: 500}}, nil
+}
+
+func TestInitShipsReturnsAPIErrors(t *testing.T) {
+	strategy := DefaultStrategy()
+	account := NewAccount(AgentConfig{Name: "test", Rate: 1000, Burst: 100}, &strategy)
+	account.Client = shipsFailClient{}
+	game := NewGame(account)
+	if err := game.InitShips(); err == nil {
+		t.Fatal("InitShips did not return the API error")
+	}
+}
+
+func TestSaveReturnsWriteErrors(t *testing.T) {
+	strategy := DefaultStrategy()
+	account := NewAccount(AgentConfig{Name: "test", Rate: 1000, Burst: 100}, &strategy)
+	game := NewGame(account)
+	game.Account.StatePath = filepath.Join(t.TempDir(), "missing", "game.state.json")
+	if err := game.Save(); err == nil {
+		t.Fatal("Save did not return the write error")
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/Dutchy-/spacetrader-go/main
 
-go 1.19
+go 1.21
 
 replace github.com/Dutchy-/spacetrader-go/client v0.0.0 => ../client
 
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/main/fsm"
+)
+
+// HAULER_IDLE_WAIT is by default how long an idle hauler waits before checking for orders again
+const HAULER_IDLE_WAIT = 30 * time.Second
+
+type HaulerState string
+
+const (
+	HAULER_IDLE    HaulerState = "IDLE"
+	HAULER_WORKING HaulerState = "WORKING"
+)
+
+// Hauler is a ship without mining equipment that carries out the tasks it is
+// given: delivering, trading, scouting and refueling.
+type Hauler struct {
+	Ship
+	State HaulerState
+}
+
+// HaulerContext is what the guards and actions of the hauler machine work on.
+type HaulerContext struct {
+	Ship      *Hauler
+	GameState *State
+}
+
+type haulerGuard = fsm.Guard[HaulerContext]
+type haulerAction = fsm.Action[HaulerContext]
+
+// HaulerMachine declares how a hauler works through the tasks the dispatcher
+// gives it. The hauler needs the waypoints of its system before there is
+// anything to plan for it.
+var HaulerMachine = fsm.New("hauler", func(c HaulerContext) HaulerState { return c.Ship.DeriveState() }).
+	When(HAULER_IDLE, systemUnknown, HAULER_IDLE, scanSystemAction).
+	When(HAULER_IDLE, hasTask, HAULER_WORKING).
+	Always(HAULER_IDLE, HAULER_IDLE, idleAction).
+	When(HAULER_WORKING, taskCancelled, HAULER_IDLE, dropTaskAction).
+	When(HAULER_WORKING, hasNoTask, HAULER_IDLE).
+	Always(HAULER_WORKING, HAULER_WORKING, runTaskAction)
+
+var (
+	systemUnknown = &haulerGuard{Name: "waypoints not scanned", Check: func(c HaulerContext) bool {
+		_, known := c.GameState.WaypointsBySystem[c.Ship.Nav.SystemSymbol]
+		return c.Ship.Task == nil && !known
+	}}
+	hasTask = &haulerGuard{Name: "has task", Check: func(c HaulerContext) bool {
+		return c.Ship.Task != nil
+	}}
+	hasNoTask = &haulerGuard{Name: "no task", Check: func(c HaulerContext) bool {
+		return c.Ship.Task == nil
+	}}
+	taskCancelled = &haulerGuard{Name: "task cancelled", Check: func(c HaulerContext) bool {
+		return c.Ship.Task != nil && c.Ship.Task.Cancelled
+	}}
+)
+
+var (
+	scanSystemAction = haulerAction{Name: "scan waypoints", Do: func(c HaulerContext) error {
+		waypoints, err := c.Ship.ScanWaypoints()
+		if err != nil {
+			return err
+		}
+		c.GameState.AddWaypoints(c.Ship.Nav.SystemSymbol, waypoints)
+		return nil
+	}}
+	idleAction = haulerAction{Name: "wait", Do: func(c HaulerContext) error {
+		c.Ship.Wait = c.Ship.Clock.Now().Add(c.Ship.Account.Strategy.Hauler.IdleWait.Duration)
+		c.Ship.Account.Events.Publish(ShipIdle{EventInfo: c.Ship.event(), Until: c.Ship.Wait})
+		return nil
+	}}
+	dropTaskAction = haulerAction{Name: "drop task", Do: func(c HaulerContext) error {
+		c.Ship.DropTask()
+		return nil
+	}}
+	runTaskAction = haulerAction{Name: "run task", Do: func(c HaulerContext) error {
+		if c.Ship.RunTask(c.GameState) {
+			c.Ship.FinishTask()
+		}
+		return nil
+	}}
+)
+
+// DeriveState returns the state that matches whether the hauler has a task.
+func (ship *Hauler) DeriveState() HaulerState {
+	if ship.Task != nil {
+		return HAULER_WORKING
+	}
+	return HAULER_IDLE
+}
+
+// InitState starts the state machine over in the state derived from the ship.
+func (ship *Hauler) InitState() {
+	ship.State = ship.DeriveState()
+}
+
+func (ship *Hauler) Run(gameState *State) {
+	ship.State = HaulerMachine.Step(ship.State, HaulerContext{Ship: ship, GameState: gameState})
+}
+
+// WriteGraph writes the state machine of the role as Graphviz DOT, after
+// checking it for states that cannot be left and transitions never taken.
+func WriteGraph(w io.Writer, role string) error {
+	var dot string
+	switch strings.ToUpper(role) {
+	case ROLE_MINER:
+		if err := MinerMachine.Validate(); err != nil {
+			return err
+		}
+		dot = MinerMachine.DOT()
+	case ROLE_HAULER:
+		if err := HaulerMachine.Validate(); err != nil {
+			return err
+		}
+		dot = HaulerMachine.DOT()
+	default:
+		return fmt.Errorf("unknown role %q, use %s or %s", role, ROLE_MINER, ROLE_HAULER)
+	}
+	_, err := io.WriteString(w, dot)
+	return err
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+type LedgerKind string
+
+const (
+	LEDGER_SELL               LedgerKind = "SELL"
+	LEDGER_PURCHASE           LedgerKind = "PURCHASE"
+	LEDGER_REFUEL             LedgerKind = "REFUEL"
+	LEDGER_SHIP_PURCHASE      LedgerKind = "SHIP_PURCHASE"
+	LEDGER_CONTRACT_ACCEPTED  LedgerKind = "CONTRACT_ACCEPTED"
+	LEDGER_CONTRACT_FULFILLED LedgerKind = "CONTRACT_FULFILLED"
+)
+
+var ledgerHeader = []string{"time", "kind", "ship", "role", "good", "waypoint", "units", "credits", "contract"}
+
+// LedgerEntry is a single change to our credits. Credits is negative for spending.
+type LedgerEntry struct {
+	Time     time.Time
+	Kind     LedgerKind
+	Ship     string
+	Role     string
+	Good     string
+	Waypoint string
+	Units    int
+	Credits  int
+	Contract string
+}
+
+// Ledger records every credit-affecting event, in memory for reports and in a
+// CSV file so the history survives restarts. It is safe for concurrent use.
+type Ledger struct {
+	mu      sync.Mutex
+	path    string
+	Entries []LedgerEntry
+}
+
+// LedgerPath returns the ledger file that belongs to a state file.
+func LedgerPath(statePath string) string {
+	return strings.TrimSuffix(statePath, ".state.json") + ".ledger.csv"
+}
+
+// Open loads the entries already in the ledger file and appends new entries to it.
+func (ledger *Ledger) Open(path string) error {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	ledger.path = path
+	ledger.Entries = nil
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return err
+	}
+	for i, record := range records {
+		if i == 0 || len(record) != len(ledgerHeader) {
+			continue
+		}
+		entry, err := parseLedgerRecord(record)
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", path, i+1, err)
+		}
+		ledger.Entries = append(ledger.Entries, entry)
+	}
+	return nil
+}
+
+func parseLedgerRecord(record []string) (LedgerEntry, error) {
+	t, err := time.Parse(time.RFC3339, record[0])
+	if err != nil {
+		return LedgerEntry{}, err
+	}
+	units, err := strconv.Atoi(record[6])
+	if err != nil {
+		return LedgerEntry{}, err
+	}
+	credits, err := strconv.Atoi(record[7])
+	if err != nil {
+		return LedgerEntry{}, err
+	}
+	return LedgerEntry{
+		Time:     t,
+		Kind:     LedgerKind(record[1]),
+		Ship:     record[2],
+		Role:     record[3],
+		Good:     record[4],
+		Waypoint: record[5],
+		Units:    units,
+		Credits:  credits,
+		Contract: record[8],
+	}, nil
+}
+
+func (entry LedgerEntry) record() []string {
+	return []string{
+		entry.Time.UTC().Format(time.RFC3339),
+		string(entry.Kind),
+		entry.Ship,
+		entry.Role,
+		entry.Good,
+		entry.Waypoint,
+		strconv.Itoa(entry.Units),
+		strconv.Itoa(entry.Credits),
+		entry.Contract,
+	}
+}
+
+// Record adds an entry and appends it to the ledger file, if there is one.
+func (ledger *Ledger) Record(entry LedgerEntry) {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	ledger.Entries = append(ledger.Entries, entry)
+	if ledger.path == "" {
+		return
+	}
+	if err := ledger.append(entry); err != nil {
+		slog.Error("Failed to write ledger", "path", ledger.path, "error", err)
+	}
+}
+
+func (ledger *Ledger) append(entry LedgerEntry) error {
+	info, err := os.Stat(ledger.path)
+	empty := errors.Is(err, os.ErrNotExist) || err == nil && info.Size() == 0
+	f, err := os.OpenFile(ledger.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if empty {
+		w.Write(ledgerHeader)
+	}
+	w.Write(entry.record())
+	w.Flush()
+	return w.Error()
+}
+
+// Handle records the credit changes among the events.
+func (ledger *Ledger) Handle(event Event) {
+	switch event := event.(type) {
+	case Sold:
+		ledger.recordTransaction(event.Ship, event.Role, event.Transaction)
+	case Bought:
+		ledger.recordTransaction(event.Ship, event.Role, event.Transaction)
+	case Refueled:
+		ledger.Record(LedgerEntry{
+			Time:     event.Time,
+			Kind:     LEDGER_REFUEL,
+			Ship:     event.Ship,
+			Role:     event.Role,
+			Good:     "FUEL",
+			Waypoint: event.Waypoint,
+			Units:    event.Units,
+			Credits:  -event.Cost,
+		})
+	case ShipPurchased:
+		ledger.Record(LedgerEntry{
+			Time:     event.Transaction.Timestamp,
+			Kind:     LEDGER_SHIP_PURCHASE,
+			Ship:     event.NewShip.Symbol,
+			Role:     string(event.NewShip.Registration.Role),
+			Waypoint: event.Transaction.WaypointSymbol,
+			Units:    1,
+			Credits:  -event.Transaction.Price,
+		})
+	case ContractUpdated:
+		switch event.Change {
+		case CONTRACT_ACCEPTED:
+			ledger.recordContract(LEDGER_CONTRACT_ACCEPTED, event.Contract, event.Payment, event.Time)
+		case CONTRACT_FULFILLED:
+			ledger.recordContract(LEDGER_CONTRACT_FULFILLED, event.Contract, event.Payment, event.Time)
+		}
+	}
+}
+
+// recordTransaction records a market sale or purchase made by the ship.
+func (ledger *Ledger) recordTransaction(ship string, role string, tx client.MarketTransaction) {
+	entry := LedgerEntry{
+		Time:     tx.Timestamp,
+		Kind:     LEDGER_SELL,
+		Ship:     ship,
+		Role:     role,
+		Good:     tx.TradeSymbol,
+		Waypoint: tx.WaypointSymbol,
+		Units:    tx.Units,
+		Credits:  tx.TotalPrice,
+	}
+	if tx.Type == client.PURCHASE {
+		entry.Kind = LEDGER_PURCHASE
+		entry.Credits = -tx.TotalPrice
+	}
+	ledger.Record(entry)
+}
+
+// recordContract records a contract payment. The payment is attributed to the
+// first good the contract asks for.
+func (ledger *Ledger) recordContract(kind LedgerKind, contract client.Contract, payment int, t time.Time) {
+	entry := LedgerEntry{
+		Time:     t,
+		Kind:     kind,
+		Credits:  payment,
+		Contract: contract.Id,
+	}
+	if contract.Terms.Deliver != nil && len(*contract.Terms.Deliver) > 0 {
+		deliver := (*contract.Terms.Deliver)[0]
+		entry.Good = deliver.TradeSymbol
+		entry.Waypoint = deliver.DestinationSymbol
+	}
+	ledger.Record(entry)
+}
+
+// ProfitBy sums the credits of all entries grouped by the given key.
+func (ledger *Ledger) ProfitBy(key func(LedgerEntry) string) map[string]int {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	profit := map[string]int{}
+	for _, entry := range ledger.Entries {
+		profit[key(entry)] += entry.Credits
+	}
+	return profit
+}
+
+// WriteReport writes the profit per ship, role, good and hour as text tables.
+func (ledger *Ledger) WriteReport(w io.Writer) {
+	orNone := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	sections := []struct {
+		title string
+		key   func(LedgerEntry) string
+	}{
+		{"SHIP", func(e LedgerEntry) string { return orNone(e.Ship) }},
+		{"ROLE", func(e LedgerEntry) string { return orNone(e.Role) }},
+		{"GOOD", func(e LedgerEntry) string { return orNone(e.Good) }},
+		{"HOUR", func(e LedgerEntry) string { return e.Time.UTC().Truncate(time.Hour).Format("2006-01-02 15:04") }},
+	}
+	for _, section := range sections {
+		profit := ledger.ProfitBy(section.key)
+		keys := sortedKeys(profit)
+		if section.title != "HOUR" {
+			sort.SliceStable(keys, func(i, j int) bool { return profit[keys[i]] > profit[keys[j]] })
+		}
+		fmt.Fprintf(w, "%-24s %12s\n", section.title, "PROFIT")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%-24s %12d\n", key, profit[key])
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// WriteCSV writes all entries as CSV with a header line.
+func (ledger *Ledger) WriteCSV(w io.Writer) error {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	cw := csv.NewWriter(w)
+	cw.Write(ledgerHeader)
+	for _, entry := range ledger.Entries {
+		cw.Write(entry.record())
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportCSV writes the ledger as CSV to the file at path, or to stdout for "-".
+func (ledger *Ledger) ExportCSV(path string) error {
+	if path == "-" {
+		return ledger.WriteCSV(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ledger.WriteCSV(f)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+func TestLedgerRecordsEvents(t *testing.T) {
+	at := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "test.ledger.csv")
+	ledger := &Ledger{}
+	if err := ledger.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	bus := NewEventBus()
+	bus.Subscribe(ledger.Handle)
+
+	bus.Publish(Sold{EventInfo: EventInfo{Time: at, Agent: "test", Ship: "TEST-1"}, Role: "EXCAVATOR",
+		Transaction: client.MarketTransaction{Timestamp: at, Type: client.SELL, TradeSymbol: "ICE_WATER", WaypointSymbol: testStation, Units: 20, TotalPrice: 400}})
+	bus.Publish(Bought{EventInfo: EventInfo{Time: at, Agent: "test", Ship: "TEST-2"}, Role: "HAULER",
+		Transaction: client.MarketTransaction{Timestamp: at, Type: client.PURCHASE, TradeSymbol: "IRON_ORE", WaypointSymbol: testStation, Units: 10, TotalPrice: 150}})
+	bus.Publish(Refueled{EventInfo: EventInfo{Time: at, Agent: "test", Ship: "TEST-1"}, Role: "EXCAVATOR", Waypoint: testStation, Units: 80, Cost: 72})
+	newShip := client.Ship{Symbol: "TEST-3"}
+	newShip.Registration.Role = client.ShipRoleEXCAVATOR
+	bus.Publish(ShipPurchased{EventInfo: EventInfo{Time: at, Agent: "test"}, NewShip: newShip,
+		Transaction: client.ShipyardTransaction{Timestamp: at, WaypointSymbol: "X1-TEST-S1", Price: 70000}})
+	// events that do not change our credits are not recorded
+	bus.Publish(Extracted{EventInfo: EventInfo{Time: at, Agent: "test", Ship: "TEST-1"}, Waypoint: testField})
+
+	want := []LedgerEntry{
+		{Time: at, Kind: LEDGER_SELL, Ship: "TEST-1", Role: "EXCAVATOR", Good: "ICE_WATER", Waypoint: testStation, Units: 20, Credits: 400},
+		{Time: at, Kind: LEDGER_PURCHASE, Ship: "TEST-2", Role: "HAULER", Good: "IRON_ORE", Waypoint: testStation, Units: 10, Credits: -150},
+		{Time: at, Kind: LEDGER_REFUEL, Ship: "TEST-1", Role: "EXCAVATOR", Good: "FUEL", Waypoint: testStation, Units: 80, Credits: -72},
+		{Time: at, Kind: LEDGER_SHIP_PURCHASE, Ship: "TEST-3", Role: "EXCAVATOR", Waypoint: "X1-TEST-S1", Units: 1, Credits: -70000},
+	}
+	if !reflect.DeepEqual(ledger.Entries, want) {
+		t.Errorf("entries = %+v, want %+v", ledger.Entries, want)
+	}
+
+	byShip := ledger.ProfitBy(func(e LedgerEntry) string { return e.Ship })
+	if want := map[string]int{"TEST-1": 328, "TEST-2": -150, "TEST-3": -70000}; !reflect.DeepEqual(byShip, want) {
+		t.Errorf("profit by ship = %v, want %v", byShip, want)
+	}
+	total := ledger.ProfitBy(func(e LedgerEntry) string { return "" })[""]
+	if total != 400-150-72-70000 {
+		t.Errorf("total profit = %d, want %d", total, 400-150-72-70000)
+	}
+
+	// the entries survive a restart
+	reopened := &Ledger{}
+	if err := reopened.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reopened.Entries, want) {
+		t.Errorf("entries read back = %+v, want %+v", reopened.Entries, want)
+	}
+}
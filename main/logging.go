@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// SetupLogging makes slog write to out at the given level, as JSON or as
+// key=value text, and routes the standard logger through it.
+func SetupLogging(out io.Writer, level string, json bool) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	if json {
+		handler = slog.NewJSONHandler(out, opts)
+	} else {
+		handler = slog.NewTextHandler(out, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// Fatal logs the error and exits. Unlike log.Fatal it logs at error level, so
+// the message is not filtered out when the log level is raised.
+func Fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// Log returns a logger with the ship's agent, symbol, role and location as fields.
+func (ship *Ship) Log() *slog.Logger {
+	return slog.With("agent", ship.Account.Name, "ship", ship.Symbol, "role", ship.Registration.Role, "waypoint", ship.Nav.WaypointSymbol)
+}
+
+// Log returns a logger with the miner's fields and its state.
+func (ship *Miner) Log() *slog.Logger {
+	return ship.Ship.Log().With("state", ship.State)
+}
+
+// shipFromPath returns the ship symbol in an API path like /my/ships/{symbol}/..., if any.
+func shipFromPath(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i+2 < len(parts); i++ {
+		if parts[i] == "my" && parts[i+1] == "ships" {
+			return parts[i+2]
+		}
+	}
+	return ""
+}
@@ -2,14 +2,23 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/time/rate"
 
 	"github.com/Dutchy-/spacetrader-go/client"
+	"github.com/Dutchy-/spacetrader-go/main/sim"
 )
 
 //go:generate oapi-codegen --package=client -generate=types -o ./client/types.go https://stoplight.io/api/v1/projects/spacetraders/spacetraders/nodes/reference/SpaceTraders.json?fromExportButton=true&snapshotType=http_service&deref=optimizedBundle
@@ -18,74 +27,312 @@ import (
 const (
 	API_URL    = "https://api.spacetraders.io/v2"
 	TOKEN_FILE = "token"
+	// SIM_SPEEDUP makes travel and cooldowns in the simulator faster than in the live game
+	SIM_SPEEDUP = 10
 )
 
-var Token string
-
-func AddBearer(ctx context.Context, req *http.Request) error {
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", Token))
-	return nil
-}
-
 type RLHTTPClient struct {
 	client      *http.Client
 	Ratelimiter *rate.Limiter
+	// Agent is the name of the agent the requests are made for, for logs and metrics
+	Agent    string
+	requests atomic.Int64
 }
 
 func (c *RLHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	ctx := context.Background()
+	waitStart := time.Now()
 	err := c.Ratelimiter.Wait(ctx) // This is a blocking call. Honors the rate limit
 	if err != nil {
 		return nil, err
 	}
+	Stats.LimiterWait(c.Agent, time.Since(waitStart))
+	logger := slog.With("agent", c.Agent, "request_id", c.requests.Add(1), "method", req.Method, "path", req.URL.Path)
+	if ship := shipFromPath(req.URL.Path); ship != "" {
+		logger = logger.With("ship", ship)
+	}
+	start := time.Now()
 	resp, err := c.client.Do(req)
 	if err != nil {
+		logger.Error("Request failed", "error", err, "duration", time.Since(start))
 		return nil, err
 	}
+	Stats.Request(c.Agent, req.URL.Path, resp.StatusCode)
+	logger.Debug("Request", "status", resp.StatusCode, "duration", time.Since(start))
 	return resp, nil
 }
 
-func NewClient(rl *rate.Limiter) *RLHTTPClient {
+func NewClient(agent string, rl *rate.Limiter) *RLHTTPClient {
 	c := &RLHTTPClient{
 		client:      http.DefaultClient,
 		Ratelimiter: rl,
+		Agent:       agent,
 	}
 	return c
 }
 
-var Client client.ClientWithResponsesInterface
-
-func main() {
-	fmt.Println("starting client")
-	b, err := os.ReadFile(TOKEN_FILE)
+// StartSimulator serves the offline simulator on a local port and returns it
+// with its URL.
+func StartSimulator() (*sim.Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		panic(err)
+		Fatal("Failed to start simulator", err)
 	}
-	Token = string(b)
-	// Client, err = client.NewClientWithResponses(API_URL, client.WithHTTPClient(NewClient(rate.NewLimiter(2, 7))))
-	Client, err = client.NewClientWithResponses(API_URL, client.WithRequestEditorFn(AddBearer), client.WithHTTPClient(NewClient(rate.NewLimiter(2, 7))))
+	server := sim.New(sim.Options{
+		TravelTime:      time.Second / SIM_SPEEDUP,
+		ExtractCooldown: 70 * time.Second / SIM_SPEEDUP,
+		SurveyCooldown:  70 * time.Second / SIM_SPEEDUP,
+		ScanCooldown:    70 * time.Second / SIM_SPEEDUP,
+	})
+	go func() {
+		Fatal("Simulator stopped", http.Serve(ln, server))
+	}()
+	slog.Info("Simulator listening", "address", ln.Addr().String())
+	return server, "http://" + ln.Addr().String()
+}
+
+// StartStatusServer serves the dashboard and the metrics on the given address.
+func StartStatusServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Stats)
+	mux.Handle("/", Board)
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Fatalf("Failed to start client: %v\n", err)
+		Fatal("Failed to start status server", err)
 	}
+	go func() {
+		Fatal("Status server stopped", http.Serve(ln, mux))
+	}()
+	slog.Info("Status server listening", "address", ln.Addr().String())
+}
 
-	game := NewGame()
-	game.Run()
+func main() {
+	configFile := flag.String("config", os.Getenv(CONFIG_ENV_PREFIX+"CONFIG"), "JSON config file, values in it are overridden by SPACETRADERS_* environment variables and flags")
+	apiURL := flag.String("api-url", API_URL, "URL of the SpaceTraders API")
+	stateFile := flag.String("state-file", STATE_FILE, "file the game state is saved in")
+	rateLimit := flag.Float64("rate", DEFAULT_RATE, "requests per second each agent may make")
+	burst := flag.Int("burst", DEFAULT_BURST, "requests each agent may make in a burst")
+	reserve := flag.Int("reserve", DEFAULT_CREDIT_RESERVE, "credits to keep in reserve when buying ships")
+	simulate := flag.Bool("sim", false, "run against the offline simulator instead of the live API")
+	record := flag.String("record", "", "record all API traffic to this cassette file")
+	replay := flag.String("replay", "", "replay API traffic from this cassette file instead of using the network")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logJSON := flag.Bool("log-json", false, "write logs as JSON lines")
+	report := flag.Bool("report", false, "print the profit per ship, role, good and hour from the ledger and exit")
+	export := flag.String("export-ledger", "", "write the ledger as CSV to this file and exit, - for stdout")
+	asJSON := flag.Bool("json", false, "print command results as JSON instead of a table")
+	configDir := flag.String("config-dir", DefaultConfigDir(), "directory the token and registration are stored in")
+	autoRegister := flag.Bool("auto-register", false, "register again with the stored symbol and faction when the server was reset")
+	agentsFile := flag.String("agents", "", "JSON file listing several agents to run side by side")
+	agentName := flag.String("agent", "", "agent from the agents file that commands, -report and -export-ledger apply to, the first by default")
+	tui := flag.Bool("tui", false, "show the fleet and the log in a terminal UI")
+	statusAddr := flag.String("http", "", "serve the dashboard and /metrics on this address, e.g. localhost:9090")
+	graph := flag.String("graph", "", "print the state machine of a role, miner or hauler, as Graphviz DOT and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n\nWithout a command the automated game loop runs.\n\n", os.Args[0])
+		CommandUsage(flag.CommandLine.Output())
+		fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
-	// rresp, err := Client.RegisterWithResponse(context.TODO(), client.RegisterJSONRequestBody{
-	// 	Faction: "QUANTUM",
-	// 	Symbol:  "DVTCHY",
-	// })
-	// if err != nil {
-	// 	panic(err)
-	// }
+	var logOut io.Writer = os.Stderr
+	if *tui {
+		Screen = NewTUI(os.Stdout)
+		logOut = Screen
+		go Screen.Run()
+	}
+	if err := SetupLogging(logOut, *logLevel, *logJSON); err != nil {
+		Fatal("Invalid log level", err)
+	}
+	if *graph != "" {
+		if err := WriteGraph(os.Stdout, *graph); err != nil {
+			Fatal("Cannot print state machine", err)
+		}
+		return
+	}
+	slog.Info("starting client")
 
-	// if rresp.StatusCode() == 201 {
-	// 	regData := rresp.JSON201.Data
-	// 	fmt.Printf("Register data: %+v\n", regData)
-	// 	fmt.Printf("Token: %+v", regData.Token)
-	// } else {
-	// 	fmt.Println("Failed to register")
-	// 	fmt.Printf("%s\n", rresp.Body)
-	// }
+	config := DefaultConfig()
+	if *configFile != "" {
+		var err error
+		config, err = LoadConfig(*configFile)
+		if err != nil {
+			Fatal("Failed to load config", err)
+		}
+	}
+	// prepare applies the environment and the flags given on the command line,
+	// which override the config file, also when it is reloaded
+	prepare := func(config *Config) error {
+		if err := config.ApplyEnv(); err != nil {
+			return err
+		}
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "api-url":
+				config.APIURL = *apiURL
+			case "state-file":
+				config.StateFile = *stateFile
+			case "config-dir":
+				config.ConfigDir = *configDir
+			case "rate":
+				config.Rate = *rateLimit
+			case "burst":
+				config.Burst = *burst
+			case "reserve":
+				config.Strategy.CreditReserve = *reserve
+			}
+		})
+		if *agentsFile != "" {
+			agents, err := LoadAgentConfigs(*agentsFile)
+			if err != nil {
+				return err
+			}
+			config.Agents = agents
+		}
+		return nil
+	}
+	if err := prepare(&config); err != nil {
+		Fatal("Invalid config", err)
+	}
+	if err := config.Validate(); err != nil {
+		Fatal("Invalid config", err)
+	}
+	var notifier *Notifier
+	if len(config.Notifications.Events) > 0 {
+		var err error
+		notifier, err = NewNotifier(config.Notifications)
+		if err != nil {
+			Fatal("Failed to set up notifications", err)
+		}
+	}
+	var watcher *ConfigWatcher
+	if *configFile != "" {
+		watcher = NewConfigWatcher(*configFile, config, prepare)
+	}
+	configs := config.AgentConfigs()
+	if (*record != "" || *replay != "") && len(configs) > 1 {
+		Fatal("Cannot record or replay", fmt.Errorf("%d agents configured, cassettes hold the traffic of a single agent", len(configs)))
+	}
+	accounts := []*Account{}
+	for _, agentConfig := range configs {
+		account := NewAccount(agentConfig, &config.Strategy)
+		account.Watcher = watcher
+		account.Events.Subscribe(Stats.Handle)
+		account.Events.Subscribe(Board.Handle)
+		if notifier != nil {
+			account.Events.Subscribe(notifier.Handle)
+		}
+		if *replay != "" {
+			account.StatePath = ModeStatePath(account.StatePath, "replay")
+		} else if *simulate {
+			account.StatePath = ModeStatePath(account.StatePath, "sim")
+			// Keep registrations in the simulator away from the real token
+			account.Creds.Dir = filepath.Join(account.Creds.Dir, "sim")
+		}
+		if err := account.Ledger.Open(LedgerPath(account.StatePath)); err != nil {
+			Fatal("Failed to open ledger", err)
+		}
+		accounts = append(accounts, account)
+	}
+	// Commands and ledger reports work on a single agent
+	selected := accounts[0]
+	for _, account := range accounts {
+		if account.Name == *agentName {
+			selected = account
+		}
+	}
+	if *agentName != "" && selected.Name != *agentName {
+		Fatal("Unknown agent", fmt.Errorf("%q is not configured", *agentName))
+	}
+	if *report {
+		selected.Ledger.WriteReport(os.Stdout)
+		return
+	}
+	if *export != "" {
+		if err := selected.Ledger.ExportCSV(*export); err != nil {
+			Fatal("Failed to export ledger", err)
+		}
+		return
+	}
+	if flag.NArg() > 0 {
+		accounts = []*Account{selected}
+	}
 
+	if *statusAddr != "" {
+		StartStatusServer(*statusAddr)
+	}
+	// all agents play in the same simulator, the first one without a token
+	var simulator *sim.Server
+	simURL := ""
+	for _, account := range accounts {
+		url := config.APIURL
+		switch {
+		case *replay != "":
+			// The cassette answers every request, so there is no server or token
+		case *simulate && simulator == nil:
+			simulator, simURL = StartSimulator()
+			url = simURL
+		case *simulate:
+			account.Token = "sim-" + account.Name
+			simulator.AddAgent(strings.ToUpper(account.Name), account.Token)
+			url = simURL
+		default:
+			token, err := account.Creds.LoadToken()
+			if err != nil && flag.Arg(0) != "register" && !*autoRegister {
+				Fatal("No token found, register an agent with the register command first", err)
+			}
+			account.Token = token
+		}
+		var doer client.HttpRequestDoer = NewClient(account.Name, account.Limiter)
+		if *replay != "" {
+			replayer, err := NewReplayer(*replay, url)
+			if err != nil {
+				Fatal("Failed to load cassette", err)
+			}
+			doer = replayer
+		} else if *record != "" {
+			recorder, err := NewRecorder(doer, *record, url)
+			if err != nil {
+				Fatal("Failed to create cassette", err)
+			}
+			defer recorder.Close()
+			doer = recorder
+		}
+		if err := account.Connect(url, doer); err != nil {
+			Fatal("Failed to start client", err)
+		}
+		if *replay == "" && flag.Arg(0) != "register" {
+			if err := CheckToken(account, *autoRegister); err != nil {
+				Fatal("Cannot use the token of agent "+account.Name, err)
+			}
+		}
+	}
+
+	if flag.NArg() > 0 {
+		if err := RunCommand(selected, flag.Args(), os.Stdout, *asJSON); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if watcher != nil {
+		go watcher.Run(CONFIG_POLL_INTERVAL)
+	}
+	if notifier != nil {
+		go notifier.Run()
+	}
+	wg := sync.WaitGroup{}
+	for _, account := range accounts {
+		game := NewGame(account)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := game.Run(); err != nil {
+				game.Log().Error("Game stopped", "error", err)
+			}
+		}()
+	}
+	wg.Wait()
 }
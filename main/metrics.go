@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LIMITER_WAIT_BUCKETS are the upper bounds in seconds of the rate limiter wait histogram
+var LIMITER_WAIT_BUCKETS = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// pathParams are the API path segments that are followed by an identifier, so
+// requests can be counted per endpoint instead of per ship or waypoint.
+var pathParams = map[string]string{
+	"agents":    "{agentSymbol}",
+	"contracts": "{contractId}",
+	"factions":  "{factionSymbol}",
+	"ships":     "{shipSymbol}",
+	"systems":   "{systemSymbol}",
+	"waypoints": "{waypointSymbol}",
+}
+
+// series maps the rendered labels of a sample to its value.
+type series map[string]float64
+
+type histogram struct {
+	buckets []int
+	sum     float64
+	count   int
+}
+
+var metricFamilies = []struct {
+	name string
+	kind string
+	help string
+}{
+	{"spacetraders_credits", "gauge", "Credits held by the agent."},
+	{"spacetraders_ships", "gauge", "Number of ships by role and state."},
+	{"spacetraders_ship_cargo_utilization", "gauge", "Fraction of the cargo hold in use per ship."},
+	{"spacetraders_extracted_units_total", "counter", "Units extracted per good."},
+	{"spacetraders_sold_units_total", "counter", "Units sold per good."},
+	{"spacetraders_sold_credits_total", "counter", "Credits earned from sales per good."},
+	{"spacetraders_api_requests_total", "counter", "API requests by endpoint and status code."},
+	{"spacetraders_api_throttled_total", "counter", "API responses with status 429 Too Many Requests."},
+}
+
+// Metrics collects fleet and API statistics of all agents and writes them in
+// the Prometheus text exposition format. It is safe for concurrent use.
+type Metrics struct {
+	mu sync.Mutex
+	// gauges holds the last snapshot of every agent by metric name
+	gauges   map[string]map[string]series
+	counters map[string]series
+	// waits is the rate limiter wait histogram of every agent
+	waits map[string]*histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		gauges:   map[string]map[string]series{},
+		counters: map[string]series{},
+		waits:    map[string]*histogram{},
+	}
+}
+
+// Stats is where the games and the HTTP clients report their metrics.
+var Stats = NewMetrics()
+
+// ShipState returns a short description of what the ship is doing, for reporting.
+func ShipState(ship BaseShip) string {
+	switch ship := ship.(type) {
+	case *Miner:
+		if ship.Task != nil && ship.Task.Order != nil {
+			return string(ship.Task.Order.State)
+		}
+		return string(ship.State)
+	case *Hauler:
+		switch {
+		case ship.Task == nil:
+			return "IDLE"
+		case ship.Task.Order != nil:
+			return string(ship.Task.Order.State)
+		}
+		return string(ship.Task.Kind)
+	}
+	return ""
+}
+
+// baseShip returns the embedded Ship of one of our ship roles.
+func baseShip(ship BaseShip) *Ship {
+	switch ship := ship.(type) {
+	case *Miner:
+		return &ship.Ship
+	case *Hauler:
+		return &ship.Ship
+	}
+	return nil
+}
+
+// ObserveState takes a snapshot of the agent's gauges from the game state. It is
+// called from the game loop so the state is never read while ships are changing it.
+func (m *Metrics) ObserveState(agent string, state *State) {
+	gauges := map[string]series{
+		"spacetraders_credits":                {labels("agent", agent): float64(state.Agent.Credits)},
+		"spacetraders_ships":                  {},
+		"spacetraders_ship_cargo_utilization": {},
+	}
+	for _, s := range state.Ships {
+		ship := baseShip(s)
+		if ship == nil {
+			continue
+		}
+		gauges["spacetraders_ships"][labels("agent", agent, "role", string(ship.Registration.Role), "state", ShipState(s))]++
+		if ship.Cargo.Capacity > 0 {
+			gauges["spacetraders_ship_cargo_utilization"][labels("agent", agent, "ship", ship.Symbol)] = float64(ship.Cargo.Units) / float64(ship.Cargo.Capacity)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges[agent] = gauges
+}
+
+func (m *Metrics) add(name string, labels string, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counters[name] == nil {
+		m.counters[name] = series{}
+	}
+	m.counters[name][labels] += value
+}
+
+// Handle counts extracted and sold goods.
+func (m *Metrics) Handle(event Event) {
+	switch event := event.(type) {
+	case Extracted:
+		m.add("spacetraders_extracted_units_total", labels("agent", event.Agent, "good", event.Yield.Symbol), float64(event.Yield.Units))
+	case Sold:
+		tx := event.Transaction
+		m.add("spacetraders_sold_units_total", labels("agent", event.Agent, "good", tx.TradeSymbol), float64(tx.Units))
+		m.add("spacetraders_sold_credits_total", labels("agent", event.Agent, "good", tx.TradeSymbol), float64(tx.TotalPrice))
+	}
+}
+
+// Request counts an API response by endpoint and status code.
+func (m *Metrics) Request(agent string, path string, status int) {
+	m.add("spacetraders_api_requests_total", labels("agent", agent, "endpoint", Endpoint(path), "status", strconv.Itoa(status)), 1)
+	throttled := 0.0
+	if status == http.StatusTooManyRequests {
+		throttled = 1
+	}
+	m.add("spacetraders_api_throttled_total", labels("agent", agent), throttled)
+}
+
+// LimiterWait records how long a request of the agent waited for the rate limiter.
+func (m *Metrics) LimiterWait(agent string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.waits[agent]
+	if h == nil {
+		h = &histogram{buckets: make([]int, len(LIMITER_WAIT_BUCKETS))}
+		m.waits[agent] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range LIMITER_WAIT_BUCKETS {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// Endpoint replaces the identifiers in an API path with placeholders, turning
+// /my/ships/DVTCHY-1/extract into /my/ships/{shipSymbol}/extract.
+func Endpoint(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if param, ok := pathParams[parts[i]]; ok {
+			parts[i+1] = param
+			i++
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := &strings.Builder{}
+
+	for _, family := range metricFamilies {
+		samples := series{}
+		for k, v := range m.counters[family.name] {
+			samples[k] = v
+		}
+		for _, gauges := range m.gauges {
+			for k, v := range gauges[family.name] {
+				samples[k] = v
+			}
+		}
+		header(b, family.name, family.kind, family.help)
+		for _, key := range sortedKeys(samples) {
+			fmt.Fprintf(b, "%s{%s} %s\n", family.name, key, formatFloat(samples[key]))
+		}
+	}
+
+	name := "spacetraders_limiter_wait_seconds"
+	header(b, name, "histogram", "Time requests waited for the rate limiter.")
+	for _, agent := range sortedKeys(m.waits) {
+		h := m.waits[agent]
+		for i, bound := range LIMITER_WAIT_BUCKETS {
+			fmt.Fprintf(b, "%s_bucket{%s} %d\n", name, labels("agent", agent, "le", formatFloat(bound)), h.buckets[i])
+		}
+		fmt.Fprintf(b, "%s_bucket{%s} %d\n", name, labels("agent", agent, "le", "+Inf"), h.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", name, labels("agent", agent), formatFloat(h.sum))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", name, labels("agent", agent), h.count)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+func header(b *strings.Builder, name string, kind string, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labels renders name and value pairs as a Prometheus label set.
+func labels(pairs ...string) string {
+	parts := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+"="+quote(pairs[i+1]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func quote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
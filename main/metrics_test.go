@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/my/agent", "/my/agent"},
+		{"/my/ships", "/my/ships"},
+		{"/my/ships/DVTCHY-1/extract", "/my/ships/{shipSymbol}/extract"},
+		{"/my/ships/DVTCHY-2A/extract", "/my/ships/{shipSymbol}/extract"},
+		{"my/ships/DVTCHY-1/nav/", "/my/ships/{shipSymbol}/nav"},
+		{"/my/contracts/clhzx0ph3/deliver", "/my/contracts/{contractId}/deliver"},
+		{"/agents/DVTCHY", "/agents/{agentSymbol}"},
+		{"/systems/X1-DF55/waypoints", "/systems/{systemSymbol}/waypoints"},
+		{"/systems/X1-DF55/waypoints/X1-DF55-20250Z/market", "/systems/{systemSymbol}/waypoints/{waypointSymbol}/market"},
+		{"/systems/X1-ZA40/waypoints/X1-ZA40-69371X/market", "/systems/{systemSymbol}/waypoints/{waypointSymbol}/market"},
+	}
+	for _, test := range tests {
+		if got := Endpoint(test.path); got != test.want {
+			t.Errorf("Endpoint(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+// wantMetrics is what TestMetricsWriteTo should write, in the Prometheus text
+// exposition format.
+const wantMetrics = `# HELP spacetraders_credits Credits held by the agent.
+# TYPE spacetraders_credits gauge
+spacetraders_credits{agent="test"} 1500
+# HELP spacetraders_ships Number of ships by role and state.
+# TYPE spacetraders_ships gauge
+spacetraders_ships{agent="test",role="EXCAVATOR",state="EXTRACT"} 2
+# HELP spacetraders_ship_cargo_utilization Fraction of the cargo hold in use per ship.
+# TYPE spacetraders_ship_cargo_utilization gauge
+spacetraders_ship_cargo_utilization{agent="test",ship="TEST-1"} 0.5
+spacetraders_ship_cargo_utilization{agent="test",ship="TEST-2"} 0
+# HELP spacetraders_extracted_units_total Units extracted per good.
+# TYPE spacetraders_extracted_units_total counter
+spacetraders_extracted_units_total{agent="test",good="ICE_WATER"} 12
+# HELP spacetraders_sold_units_total Units sold per good.
+# TYPE spacetraders_sold_units_total counter
+spacetraders_sold_units_total{agent="test",good="ICE_WATER"} 20
+# HELP spacetraders_sold_credits_total Credits earned from sales per good.
+# TYPE spacetraders_sold_credits_total counter
+spacetraders_sold_credits_total{agent="test",good="ICE_WATER"} 400
+# HELP spacetraders_api_requests_total API requests by endpoint and status code.
+# TYPE spacetraders_api_requests_total counter
+spacetraders_api_requests_total{agent="test",endpoint="/my/ships/{shipSymbol}/extract",status="201"} 2
+spacetraders_api_requests_total{agent="test",endpoint="/my/ships/{shipSymbol}/extract",status="429"} 1
+# HELP spacetraders_api_throttled_total API responses with status 429 Too Many Requests.
+# TYPE spacetraders_api_throttled_total counter
+spacetraders_api_throttled_total{agent="test"} 1
+# HELP spacetraders_limiter_wait_seconds Time requests waited for the rate limiter.
+# TYPE spacetraders_limiter_wait_seconds histogram
+spacetraders_limiter_wait_seconds_bucket{agent="test",le="0.01"} 1
+spacetraders_limiter_wait_seconds_bucket{agent="test",le="0.05"} 1
+spacetraders_limiter_wait_seconds_bucket{agent="test",le="0.1"} 1
+spacetraders_limiter_wait_seconds_bucket{agent="test",le="0.25"} 1
+spacetraders_limiter_wait_seconds_bucket{agent="test",le="0.5"} 1
+spacetraders_limiter_wait_seconds_bucket{agent="test",le="1"} 1
+spacetraders_limiter_wait_seconds_bucket{agent="test",le="2.5"} 2
+spacetraders_limiter_wait_seconds_bucket{agent="test",le="5"} 2
+spacetraders_limiter_wait_seconds_bucket{agent="test",le="10"} 2
+spacetraders_limiter_wait_seconds_bucket{agent="test",le="+Inf"} 2
+spacetraders_limiter_wait_seconds_sum{agent="test"} 2
+spacetraders_limiter_wait_seconds_count{agent="test"} 2
+`
+
+func TestMetricsWriteTo(t *testing.T) {
+	m := NewMetrics()
+	state := &State{Agent: client.Agent{Credits: 1500}}
+	for i, units := range []int{15, 0} {
+		ship := &Miner{State: EXTRACT}
+		ship.Symbol = fmt.Sprintf("TEST-%d", i+1)
+		ship.Registration.Role = client.ShipRoleEXCAVATOR
+		ship.Cargo = client.ShipCargo{Capacity: 30, Units: units}
+		state.Ships = append(state.Ships, ship)
+	}
+	m.ObserveState("test", state)
+	info := EventInfo{Agent: "test", Ship: "TEST-1"}
+	m.Handle(Extracted{EventInfo: info, Yield: client.ExtractionYield{Symbol: "ICE_WATER", Units: 7}})
+	m.Handle(Extracted{EventInfo: info, Yield: client.ExtractionYield{Symbol: "ICE_WATER", Units: 5}})
+	m.Handle(Sold{EventInfo: info, Transaction: client.MarketTransaction{TradeSymbol: "ICE_WATER", Units: 20, TotalPrice: 400}})
+	m.Request("test", "/my/ships/TEST-1/extract", 201)
+	m.Request("test", "/my/ships/TEST-2/extract", 201)
+	m.Request("test", "/my/ships/TEST-1/extract", 429)
+	m.LimiterWait("test", 0)
+	m.LimiterWait("test", 2*time.Second)
+
+	b := &strings.Builder{}
+	if _, err := m.WriteTo(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != wantMetrics {
+		t.Errorf("WriteTo wrote\n%s\nwant\n%s", got, wantMetrics)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+	"github.com/Dutchy-/spacetrader-go/main/fsm"
+	intersect "github.com/juliangruber/go-intersect"
+)
+
+// MinerContext is what the guards and actions of the miner machine work on.
+type MinerContext struct {
+	Ship      *Miner
+	GameState *State
+}
+
+type minerGuard = fsm.Guard[MinerContext]
+type minerAction = fsm.Action[MinerContext]
+
+// MinerMachine declares how a miner plays: it mines the field of its task,
+// delivers contract goods, sells the rest and refuels along the way. Guards do
+// not call the API or change the ship or the game state, so every decision can
+// be checked from a snapshot of both.
+var MinerMachine = fsm.New("miner", func(c MinerContext) MinerState { return c.Ship.DeriveState() }).
+	Always(REFUEL, DOCKED, refuelAction).
+	When(DOCKED, hasContractGood, SELL_REMAINING, deliverAction).
+	Always(DOCKED, SELL_REMAINING).
+	Always(UPDATE_MARKET, ORBIT_STATION, updateMarketAction).
+	// a failed sale, dock, undock or navigation is tried again, once the ship was
+	// compared with the server
+	When(SELL_REMAINING, canSellCargo, SELL_REMAINING, sellAction).OnError(SELL_REMAINING).
+	Always(SELL_REMAINING, ORBIT_STATION, undockAction).OnError(SELL_REMAINING).
+	When(ORBIT_STATION, marketUnknown, UPDATE_MARKET).
+	When(ORBIT_STATION, canDeliverOrSellHere, DOCKED, dockAction).OnError(ORBIT_STATION).
+	When(ORBIT_STATION, lowFuel, REFUEL, dockAction).OnError(ORBIT_STATION).
+	When(ORBIT_STATION, mixedCargo, FIND_SELL).
+	When(ORBIT_STATION, noMiningField, START_TRAVEL, scanWaypointsAction).
+	Always(ORBIT_STATION, START_TRAVEL).
+	When(START_TRAVEL, noMiningField, ORBIT_STATION).
+	When(START_TRAVEL, awayFromField, IN_TRANSIT, navigateToFieldAction).OnError(START_TRAVEL).
+	Always(START_TRAVEL, IN_TRANSIT).
+	When(FIND_SELL, waypointsUnknown, FIND_SELL, scanWaypointsAction).
+	When(FIND_SELL, sellMarketElsewhere, IN_TRANSIT, navigateToSellAction).OnError(FIND_SELL).
+	When(FIND_SELL, canSellHere, DOCKED, dockAction).OnError(FIND_SELL).
+	Always(FIND_SELL, JETTISON).
+	When(JETTISON, hasCargo, JETTISON, jettisonAction).
+	Always(JETTISON, ORBIT_STATION).
+	When(ORBIT_ASTEROID, fullWithContractGood, IN_TRANSIT, navigateToContractAction).OnError(ORBIT_ASTEROID).
+	When(ORBIT_ASTEROID, isFull, FIND_SELL).
+	When(ORBIT_ASTEROID, haveSurvey, EXTRACT, targetSurveyAction).
+	When(ORBIT_ASTEROID, canClaimSurveying, SURVEY, claimSurveyingAction).
+	// another miner is surveying this field, extract without a survey until it is done
+	Always(ORBIT_ASTEROID, EXTRACT, targetNoneAction).
+	Always(SURVEY, ORBIT_ASTEROID, surveyAction).
+	When(EXTRACT, fullOrTargetUnusable, ORBIT_ASTEROID).
+	// without a survey we only extract once, so we pick up new surveys as soon as they arrive
+	When(EXTRACT, noTarget, ORBIT_ASTEROID, extractAction).OnError(ORBIT_ASTEROID).
+	Always(EXTRACT, EXTRACT, extractAction).OnError(ORBIT_ASTEROID).
+	Always(IN_TRANSIT, "", refreshAction).OnError(IN_TRANSIT)
+
+var (
+	hasContractGood = &minerGuard{Name: "has contract good", Check: func(c MinerContext) bool {
+		return c.Ship.HasContractGood()
+	}}
+	canSellCargo = &minerGuard{Name: "can sell cargo here", Check: func(c MinerContext) bool {
+		_, ok := c.Ship.sellableGood(c.GameState)
+		return ok
+	}}
+	marketUnknown = &minerGuard{Name: "market not seen", Check: func(c MinerContext) bool {
+		_, ok := c.GameState.Markets[c.Ship.Nav.WaypointSymbol]
+		return !ok && c.GameState.Waypoints[c.Ship.Nav.WaypointSymbol].HasMarket()
+	}}
+	canDeliverOrSellHere = &minerGuard{Name: "contract destination or can sell here", Check: func(c MinerContext) bool {
+		return c.Ship.ContractDestination() == c.Ship.Nav.WaypointSymbol || c.Ship.CanSellHere(c.GameState.Markets[c.Ship.Nav.WaypointSymbol])
+	}}
+	lowFuel = &minerGuard{Name: "low fuel", Check: func(c MinerContext) bool {
+		return c.Ship.HasLowFuel(c.Ship.Account.Strategy.Miner.LowFuel)
+	}}
+	mixedCargo = &minerGuard{Name: "more than one good", Check: func(c MinerContext) bool {
+		return len(c.Ship.Cargo.Inventory) > 1
+	}}
+	noMiningField = &minerGuard{Name: "no field known", Check: func(c MinerContext) bool {
+		return c.Ship.MiningField(c.GameState) == nil
+	}}
+	awayFromField = &minerGuard{Name: "away from field", Check: func(c MinerContext) bool {
+		return c.Ship.Nav.WaypointSymbol != c.Ship.MiningField(c.GameState).Symbol
+	}}
+	waypointsUnknown = &minerGuard{Name: "waypoints not scanned", Check: func(c MinerContext) bool {
+		_, ok := c.GameState.WaypointsBySystem[c.Ship.Nav.SystemSymbol]
+		return !ok
+	}}
+	sellMarketElsewhere = &minerGuard{Name: "market elsewhere", Check: func(c MinerContext) bool {
+		wp, here := c.Ship.findSell(c.GameState)
+		return wp != "" && !here
+	}}
+	canSellHere = &minerGuard{Name: "can sell here", Check: func(c MinerContext) bool {
+		_, here := c.Ship.findSell(c.GameState)
+		return here
+	}}
+	hasCargo = &minerGuard{Name: "has cargo", Check: func(c MinerContext) bool {
+		return len(c.Ship.Cargo.GetCargoGoodsExceptAntimatter()) > 0
+	}}
+	fullWithContractGood = &minerGuard{Name: "full with contract good", Check: func(c MinerContext) bool {
+		return c.Ship.IsFull() && c.Ship.HasContractGood()
+	}}
+	isFull = &minerGuard{Name: "full", Check: func(c MinerContext) bool {
+		return c.Ship.IsFull()
+	}}
+	haveSurvey = &minerGuard{Name: "usable survey", Check: func(c MinerContext) bool {
+		return c.GameState.GetBestSurvey(c.Ship.Nav.WaypointSymbol, c.Ship.GoodValues(c.GameState)) != nil
+	}}
+	// surveys are scored by the value of their goods, without any known they are all useless
+	canClaimSurveying = &minerGuard{Name: "has surveyor, goods valued and nobody surveying", Check: func(c MinerContext) bool {
+		return c.Ship.CanSurvey() && len(c.Ship.GoodValues(c.GameState)) > 0 && c.GameState.CanClaimSurveying(c.Ship.Nav.WaypointSymbol, c.Ship.Symbol)
+	}}
+	fullOrTargetUnusable = &minerGuard{Name: "full or survey unusable", Check: func(c MinerContext) bool {
+		return c.Ship.IsFull() || (c.Ship.Target != nil && !c.GameState.IsSurveyUsable(*c.Ship.Target))
+	}}
+	noTarget = &minerGuard{Name: "no survey", Check: func(c MinerContext) bool {
+		return c.Ship.Target == nil
+	}}
+)
+
+var (
+	refuelAction = minerAction{Name: "refuel", Do: func(c MinerContext) error {
+		ship := c.Ship
+		beforeFuel := ship.Fuel.Current
+		agent, trans, err := ship.Refuel()
+		if err != nil {
+			ship.Log().Error("Failed to refuel", "error", err)
+			return err
+		}
+		c.GameState.Agent = agent
+		ship.Log().Info("Refueled", "fuel", ship.Fuel.Current-beforeFuel, "price", trans.TotalPrice, "credits", agent.Credits)
+		return nil
+	}}
+	deliverAction = minerAction{Name: "deliver", Do: func(c MinerContext) error {
+		contract, err := c.Ship.Deliver()
+		if err != nil {
+			return err
+		}
+		for _, good := range *contract.Terms.Deliver {
+			c.Ship.Log().Info("Delivered", "contract", contract.Id, "good", good.TradeSymbol, "fulfilled", good.UnitsFulfilled, "required", good.UnitsRequired)
+		}
+		c.GameState.UpdateContract(contract)
+		return nil
+	}}
+	updateMarketAction = minerAction{Name: "update market", Do: func(c MinerContext) error {
+		market, err := c.Ship.UpdateMarket()
+		if err != nil {
+			return err
+		}
+		c.GameState.UpdateMarket(market)
+		return nil
+	}}
+	sellAction = minerAction{Name: "sell", Do: func(c MinerContext) error {
+		good, _ := c.Ship.sellableGood(c.GameState)
+		agent, trans, err := c.Ship.Sell(good)
+		if err != nil {
+			return err
+		}
+		c.GameState.Agent = agent
+		c.Ship.Log().Info("Sold", "good", trans.TradeSymbol, "units", trans.Units, "price", trans.TotalPrice, "credits", agent.Credits)
+		return nil
+	}}
+	undockAction = minerAction{Name: "undock", Do: func(c MinerContext) error {
+		return c.Ship.Undock()
+	}}
+	dockAction = minerAction{Name: "dock", Do: func(c MinerContext) error {
+		return c.Ship.Dock()
+	}}
+	scanWaypointsAction = minerAction{Name: "scan waypoints", Do: func(c MinerContext) error {
+		waypoints, err := c.Ship.ScanWaypoints()
+		if err != nil {
+			return err
+		}
+		c.GameState.AddWaypoints(c.Ship.Nav.SystemSymbol, waypoints)
+		return nil
+	}}
+	navigateToFieldAction = minerAction{Name: "navigate to field", Do: func(c MinerContext) error {
+		return c.Ship.navigate(c.Ship.MiningField(c.GameState).Symbol)
+	}}
+	navigateToSellAction = minerAction{Name: "navigate to market", Do: func(c MinerContext) error {
+		wp, _ := c.Ship.findSell(c.GameState)
+		return c.Ship.navigate(wp)
+	}}
+	navigateToContractAction = minerAction{Name: "navigate to contract destination", Do: func(c MinerContext) error {
+		return c.Ship.navigate(c.Ship.ContractDestination())
+	}}
+	jettisonAction = minerAction{Name: "jettison", Do: func(c MinerContext) error {
+		good := c.Ship.Cargo.GetCargoGoodsExceptAntimatter()[0]
+		if err := c.Ship.Jettison(good); err != nil {
+			return err
+		}
+		c.Ship.Log().Info("Jettisoned cargo without a market", "good", good)
+		return nil
+	}}
+	targetSurveyAction = minerAction{Name: "target best survey", Do: func(c MinerContext) error {
+		ship := c.Ship
+		target := *c.GameState.GetBestSurvey(ship.Nav.WaypointSymbol, ship.GoodValues(c.GameState))
+		ship.Target = &target
+		ship.Log().Info("Targetting survey", "survey", target.Signature, "size", target.Size, "score", ScoreSurvey(target, ship.GoodValues(c.GameState)), "valid_for", target.Expiration.Sub(ship.Clock.Now()).Round(time.Second))
+		return nil
+	}}
+	targetNoneAction = minerAction{Name: "target no survey", Do: func(c MinerContext) error {
+		c.Ship.Target = nil
+		return nil
+	}}
+	claimSurveyingAction = minerAction{Name: "claim surveying", Do: func(c MinerContext) error {
+		c.GameState.ClaimSurveying(c.Ship.Nav.WaypointSymbol, c.Ship.Symbol)
+		return nil
+	}}
+	surveyAction = minerAction{Name: "survey", Do: func(c MinerContext) error {
+		ship, gameState := c.Ship, c.GameState
+		surveys := ship.Survey()
+		gameState.ReleaseSurveying(ship.Nav.WaypointSymbol, ship.Symbol)
+		if surveys == nil {
+			return errors.New("survey failed")
+		}
+		gameState.AddSurveys(ship.Nav.WaypointSymbol, surveys)
+		for _, survey := range surveys {
+			deposits := []string{}
+			for _, dep := range survey.Deposits {
+				deposits = append(deposits, dep.Symbol)
+			}
+			ship.Log().Debug("Surveyed", "survey", survey.Signature, "size", survey.Size, "deposits", deposits)
+		}
+		return nil
+	}}
+	extractAction = minerAction{Name: "extract", Do: func(c MinerContext) error {
+		ship := c.Ship
+		e, err := ship.Extract()
+		if err != nil {
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && apiErr.IsSurveyUnusable() && ship.Target != nil {
+				ship.Log().Warn("Survey can no longer be used", "survey", ship.Target.Signature, "error", apiErr.Message)
+				c.GameState.DiscardSurvey(*ship.Target)
+			} else {
+				ship.Log().Error("Failed to extract", "error", err)
+			}
+			ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "extract", Err: err})
+			return err
+		}
+		ship.Log().Info("Extracted", "good", e.Yield.Symbol, "units", e.Yield.Units)
+		return nil
+	}}
+	refreshAction = minerAction{Name: "refresh", Do: func(c MinerContext) error {
+		return c.Ship.Refresh()
+	}}
+)
+
+func (ship *Miner) navigate(waypoint string) error {
+	ship.Log().Info("Navigating", "destination", waypoint, "free_cargo", ship.Cargo.Capacity-ship.Cargo.Units)
+	return ship.GoToSymbol(waypoint)
+}
+
+// sellableGood returns a good in the cargo the market the miner is at buys.
+func (ship *Miner) sellableGood(gameState *State) (client.TradeSymbol, bool) {
+	market := gameState.Markets[ship.Nav.WaypointSymbol]
+	if ship.Account.Strategy.IsBlacklisted(market.Symbol) {
+		return "", false
+	}
+	toSell := intersect.Hash(market.GetImportAndExchangeGoods(), ship.Cargo.GetCargoGoodsExceptAntimatter())
+	if len(toSell) == 0 {
+		return "", false
+	}
+	good, _ := toSell[0].(client.TradeSymbol)
+	return good, true
+}
+
+// findSell returns the first market in the system the miner can sell its cargo
+// at, or that we have not seen yet, and whether the miner is there already.
+func (ship *Miner) findSell(gameState *State) (string, bool) {
+	for _, wp := range gameState.WaypointsBySystem[ship.Nav.SystemSymbol] {
+		if !wp.HasMarket() {
+			continue
+		}
+		market, haveMarket := gameState.Markets[wp.Symbol]
+		if ship.Nav.WaypointSymbol != wp.Symbol && (!haveMarket || ship.CanSellHere(market)) {
+			return wp.Symbol, false
+		} else if haveMarket && ship.CanSellHere(market) {
+			return wp.Symbol, true
+		}
+	}
+	return "", false
+}
+
+// MiningField returns the asteroid field of the miner's task, or without a task
+// the field in the current system, if we know one.
+func (ship *Miner) MiningField(gameState *State) *client.ScannedWaypoint {
+	if ship.Task != nil && ship.Task.Kind == TASK_MINE {
+		if wp, ok := gameState.Waypoints[ship.Task.Waypoint]; ok {
+			return &wp
+		}
+	}
+	return gameState.GetAsteroid(ship.Nav.SystemSymbol)
+}
+
+func (ship *Miner) Run(gameState *State) {
+	if ship.State == "" {
+		ship.InitState()
+	}
+	ship.Log().Debug("Running")
+	if ship.Task != nil && ship.Task.Cancelled {
+		ship.DropTask()
+	}
+	if ship.Task != nil && ship.Task.Kind != TASK_MINE {
+		if ship.RunTask(gameState) {
+			ship.FinishTask()
+			ship.InitState()
+		}
+		return
+	}
+	ship.Contract = client.Contract{}
+	if ship.Task != nil {
+		if contract := gameState.GetContract(ship.Task.ContractId); contract != nil {
+			ship.Contract = *contract
+		}
+	}
+	ship.State = MinerMachine.Step(ship.State, MinerContext{Ship: ship, GameState: gameState})
+}
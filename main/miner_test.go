@@ -0,0 +1,525 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+	"github.com/Dutchy-/spacetrader-go/main/clock"
+)
+
+// fakeClient answers the API calls the miner tests expect and records them.
+// Any other call panics on the embedded nil interface.
+type fakeClient struct {
+	client.ClientWithResponsesInterface
+	calls  []string
+	market client.Market
+	// noSurveyor fails surveys, as for a ship without a surveyor mounted
+	noSurveyor bool
+	// failing is a call the server answers with an error
+	failing string
+}
+
+// failWith makes the server answer the call with an error.
+func failWith(call string) func(ship *Miner, state *State) {
+	return func(ship *Miner, state *State) {
+		ship.Account.Client.(*fakeClient).failing = call
+		if call == "Sell" {
+			ship.Nav.Status = client.DOCKED
+		} else {
+			ship.Nav.Status = client.INTRANSIT
+		}
+	}
+}
+
+func (f *fakeClient) GetMarketWithResponse(ctx context.Context, systemSymbol string, waypointSymbol string, reqEditors ...client.RequestEditorFn) (*client.GetMarketResponse, error) {
+	f.calls = append(f.calls, "GetMarket "+waypointSymbol)
+	resp := &client.GetMarketResponse{HTTPResponse: &http.Response{StatusCode: 200}}
+	resp.JSON200 = &struct {
+		Data client.Market `json:"data"`
+	}{Data: f.market}
+	return resp, nil
+}
+
+func (f *fakeClient) JettisonWithResponse(ctx context.Context, shipSymbol string, body client.JettisonJSONRequestBody, reqEditors ...client.RequestEditorFn) (*client.JettisonResponse, error) {
+	f.calls = append(f.calls, fmt.Sprintf("Jettison %s %d", body.Symbol, body.Units))
+	resp := &client.JettisonResponse{HTTPResponse: &http.Response{StatusCode: 200}}
+	resp.JSON200 = &struct {
+		Data struct {
+			Cargo client.ShipCargo `json:"cargo"`
+		} `json:"data"`
+	}{}
+	resp.JSON200.Data.Cargo = client.ShipCargo{Capacity: 30, Inventory: []client.ShipCargoItem{}}
+	return resp, nil
+}
+
+// DockShipWithResponse fails, as when the API does not know the ship is there.
+func (f *fakeClient) DockShipWithResponse(ctx context.Context, shipSymbol string, reqEditors ...client.RequestEditorFn) (*client.DockShipResponse, error) {
+	f.calls = append(f.calls, "Dock")
+	body := []byte(`{"error":{"code":4214,"message":"Ship is currently in-transit"}}`)
+	return &client.DockShipResponse{Body: body, HTTPResponse: &http.Response{StatusCode: 400}}, nil
+}
+
+func (f *fakeClient) OrbitShipWithResponse(ctx context.Context, shipSymbol string, reqEditors ...client.RequestEditorFn) (*client.OrbitShipResponse, error) {
+	f.calls = append(f.calls, "Orbit")
+	return client.ParseOrbitShipResponse(apiResponse(200, map[string]any{
+		"nav": client.ShipNav{Status: client.INORBIT, SystemSymbol: testSystem, WaypointSymbol: testStation},
+	}))
+}
+
+func (f *fakeClient) RefuelShipWithResponse(ctx context.Context, shipSymbol string, reqEditors ...client.RequestEditorFn) (*client.RefuelShipResponse, error) {
+	f.calls = append(f.calls, "Refuel")
+	return client.ParseRefuelShipResponse(apiResponse(200, map[string]any{
+		"agent":       client.Agent{Credits: 1000},
+		"fuel":        client.ShipFuel{Capacity: 100, Current: 100},
+		"transaction": client.MarketTransaction{TradeSymbol: string(client.TradeSymbolFUEL), Units: 1, TotalPrice: 100},
+	}))
+}
+
+func (f *fakeClient) SellCargoWithResponse(ctx context.Context, shipSymbol string, body client.SellCargoJSONRequestBody, reqEditors ...client.RequestEditorFn) (*client.SellCargoResponse, error) {
+	f.calls = append(f.calls, fmt.Sprintf("Sell %s %d", body.Symbol, body.Units))
+	if f.failing == "Sell" {
+		return client.ParseSellCargoResponse(apiResponse(400, "Market does not buy the good"))
+	}
+	return client.ParseSellCargoResponse(apiResponse(201, map[string]any{
+		"agent":       client.Agent{Credits: 1000},
+		"cargo":       testCargo(0),
+		"transaction": client.MarketTransaction{TradeSymbol: body.Symbol, Units: body.Units, TotalPrice: 10 * body.Units},
+	}))
+}
+
+func (f *fakeClient) DeliverContractWithResponse(ctx context.Context, contractId string, body client.DeliverContractJSONRequestBody, reqEditors ...client.RequestEditorFn) (*client.DeliverContractResponse, error) {
+	f.calls = append(f.calls, fmt.Sprintf("Deliver %s %d", body.TradeSymbol, body.Units))
+	contract := testContract(body.Units)
+	contract.Id = contractId
+	return client.ParseDeliverContractResponse(apiResponse(200, map[string]any{
+		"contract": contract,
+		"cargo":    testCargo(0),
+	}))
+}
+
+func (f *fakeClient) NavigateShipWithResponse(ctx context.Context, shipSymbol string, body client.NavigateShipJSONRequestBody, reqEditors ...client.RequestEditorFn) (*client.NavigateShipResponse, error) {
+	f.calls = append(f.calls, "Navigate "+body.WaypointSymbol)
+	return client.ParseNavigateShipResponse(apiResponse(200, map[string]any{
+		"fuel": client.ShipFuel{Capacity: 100, Current: 90},
+		"nav":  client.ShipNav{Status: client.INTRANSIT, SystemSymbol: testSystem, WaypointSymbol: body.WaypointSymbol},
+	}))
+}
+
+// GetShipNavWithResponse answers that the ship arrived at the asteroid field.
+func (f *fakeClient) GetShipNavWithResponse(ctx context.Context, shipSymbol string, reqEditors ...client.RequestEditorFn) (*client.GetShipNavResponse, error) {
+	f.calls = append(f.calls, "GetShipNav")
+	if f.failing == "GetShipNav" {
+		return client.ParseGetShipNavResponse(apiResponse(500, "Internal error"))
+	}
+	nav := client.ShipNav{Status: client.INORBIT, SystemSymbol: testSystem, WaypointSymbol: testField}
+	nav.Route.Destination = client.ShipNavRouteWaypoint{Symbol: testField, SystemSymbol: testSystem, Type: client.WaypointTypeASTEROIDFIELD}
+	return client.ParseGetShipNavResponse(apiResponse(200, nav))
+}
+
+func (f *fakeClient) CreateSurveyWithResponse(ctx context.Context, shipSymbol string, reqEditors ...client.RequestEditorFn) (*client.CreateSurveyResponse, error) {
+	f.calls = append(f.calls, "Survey")
+	if f.noSurveyor {
+		return client.ParseCreateSurveyResponse(apiResponse(400, "Ship does not have a surveyor mounted"))
+	}
+	return client.ParseCreateSurveyResponse(apiResponse(201, map[string]any{
+		"cooldown": client.Cooldown{ShipSymbol: shipSymbol},
+		"surveys":  []client.Survey{testSurvey},
+	}))
+}
+
+func (f *fakeClient) ExtractResourcesWithResponse(ctx context.Context, shipSymbol string, body client.ExtractResourcesJSONRequestBody, reqEditors ...client.RequestEditorFn) (*client.ExtractResourcesResponse, error) {
+	f.calls = append(f.calls, "Extract")
+	return client.ParseExtractResourcesResponse(apiResponse(201, map[string]any{
+		"cooldown":   client.Cooldown{ShipSymbol: shipSymbol},
+		"extraction": client.Extraction{ShipSymbol: shipSymbol, Yield: client.ExtractionYield{Symbol: string(client.TradeSymbolICEWATER), Units: 5}},
+		"cargo":      testCargo(5),
+	}))
+}
+
+const (
+	testSystem  = "X1-TEST"
+	testStation = "X1-TEST-A1"
+	testField   = "X1-TEST-B1"
+)
+
+var (
+	testMarketplace = client.ScannedWaypoint{Symbol: testStation, SystemSymbol: testSystem, Type: client.WaypointTypePLANET,
+		Traits: []client.WaypointTrait{{Symbol: client.WaypointTraitSymbolMARKETPLACE}}}
+	testAsteroid = client.ScannedWaypoint{Symbol: testField, SystemSymbol: testSystem, Type: client.WaypointTypeASTEROIDFIELD}
+	// testMarket buys fuel only, so a miner can not sell ore there
+	testMarket = client.Market{Symbol: testStation, Imports: []client.TradeGood{{Symbol: client.TradeSymbolFUEL}}}
+	// testBuyer buys ice water, which the miners carry
+	testBuyer = client.Market{Symbol: testStation, Imports: []client.TradeGood{{Symbol: client.TradeSymbolICEWATER}}}
+	// testPrices is testBuyer with its prices seen, so ice water has a value
+	testPrices = client.Market{Symbol: testStation, Imports: []client.TradeGood{{Symbol: client.TradeSymbolICEWATER}},
+		TradeGoods: &[]client.MarketTradeGood{{Symbol: string(client.TradeSymbolICEWATER), SellPrice: 10}}}
+	testSurvey = client.Survey{Signature: testField + "-1", Symbol: testField, Size: client.SurveySizeSMALL,
+		Deposits: []client.SurveyDeposit{{Symbol: string(client.TradeSymbolICEWATER)}}, Expiration: time.Date(2023, 6, 1, 1, 0, 0, 0, time.UTC)}
+)
+
+// testContract needs 100 units of ice water at the station, of which fulfilled
+// are delivered.
+func testContract(fulfilled int) client.Contract {
+	deliver := []client.ContractDeliverGood{{TradeSymbol: string(client.TradeSymbolICEWATER), DestinationSymbol: testStation, UnitsRequired: 100, UnitsFulfilled: fulfilled}}
+	return client.Contract{Id: "test-contract", Accepted: true, Terms: client.ContractTerms{Deliver: &deliver}}
+}
+
+// atField puts the ship in orbit at the asteroid field.
+func atField(ship *Miner, state *State) {
+	ship.Nav.WaypointSymbol = testField
+}
+
+func testCargo(units int) client.ShipCargo {
+	cargo := client.ShipCargo{Capacity: 30, Units: units, Inventory: []client.ShipCargoItem{}}
+	if units > 0 {
+		cargo.Inventory = append(cargo.Inventory, client.ShipCargoItem{Symbol: string(client.TradeSymbolICEWATER), Units: units})
+	}
+	return cargo
+}
+
+func TestMinerMachine(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   MinerState
+		cargo   client.ShipCargo
+		markets map[string]client.Market
+		// setup changes the ship and the game state from the defaults, a
+		// ship in orbit at the station with full tanks
+		setup   func(ship *Miner, state *State)
+		next    MinerState
+		actions []string
+		calls   []string
+		// check looks at the ship and game state after the step
+		check func(t *testing.T, ship *Miner, state *State)
+	}{
+		{
+			name:  "station with a market not seen",
+			state: ORBIT_STATION,
+			cargo: testCargo(0),
+			next:  UPDATE_MARKET,
+		},
+		{
+			name:    "update the market",
+			state:   UPDATE_MARKET,
+			cargo:   testCargo(0),
+			next:    ORBIT_STATION,
+			actions: []string{"update market"},
+			calls:   []string{"GetMarket " + testStation},
+		},
+		{
+			name:    "station with a market seen",
+			state:   ORBIT_STATION,
+			cargo:   testCargo(0),
+			markets: map[string]client.Market{testStation: testMarket},
+			next:    START_TRAVEL,
+		},
+		{
+			name:    "failed dock is tried again",
+			state:   ORBIT_STATION,
+			cargo:   testCargo(20),
+			markets: map[string]client.Market{testStation: testBuyer},
+			next:    ORBIT_STATION,
+			actions: []string{"dock"},
+			calls:   []string{"Dock"},
+		},
+		{
+			name:    "no market buys the cargo",
+			state:   FIND_SELL,
+			cargo:   testCargo(20),
+			markets: map[string]client.Market{testStation: testMarket},
+			next:    JETTISON,
+		},
+		{
+			name:    "jettison the cargo",
+			state:   JETTISON,
+			cargo:   testCargo(20),
+			markets: map[string]client.Market{testStation: testMarket},
+			next:    JETTISON,
+			actions: []string{"jettison"},
+			calls:   []string{"Jettison ICE_WATER 20"},
+		},
+		{
+			name:    "jettisoned everything",
+			state:   JETTISON,
+			cargo:   testCargo(0),
+			markets: map[string]client.Market{testStation: testMarket},
+			next:    ORBIT_STATION,
+		},
+		{
+			name:    "refuel",
+			state:   REFUEL,
+			cargo:   testCargo(0),
+			setup:   func(ship *Miner, state *State) { ship.Fuel.Current = 10 },
+			next:    DOCKED,
+			actions: []string{"refuel"},
+			calls:   []string{"Refuel"},
+			check: func(t *testing.T, ship *Miner, state *State) {
+				if ship.Fuel.Current != 100 || state.Agent.Credits != 1000 {
+					t.Errorf("fuel %d and credits %d after refueling", ship.Fuel.Current, state.Agent.Credits)
+				}
+			},
+		},
+		{
+			name:  "docked with a contract good",
+			state: DOCKED,
+			cargo: testCargo(20),
+			setup: func(ship *Miner, state *State) {
+				ship.Nav.Status = client.DOCKED
+				ship.Contract = testContract(0)
+			},
+			next:    SELL_REMAINING,
+			actions: []string{"deliver"},
+			calls:   []string{"Deliver ICE_WATER 20"},
+			check: func(t *testing.T, ship *Miner, state *State) {
+				if contract := state.GetContract("test-contract"); contract == nil || (*contract.Terms.Deliver)[0].UnitsFulfilled != 20 {
+					t.Errorf("contract after delivering = %v", contract)
+				}
+			},
+		},
+		{
+			name:  "docked without a contract good",
+			state: DOCKED,
+			cargo: testCargo(20),
+			setup: func(ship *Miner, state *State) { ship.Nav.Status = client.DOCKED },
+			next:  SELL_REMAINING,
+		},
+		{
+			name:    "sell the cargo",
+			state:   SELL_REMAINING,
+			cargo:   testCargo(20),
+			markets: map[string]client.Market{testStation: testBuyer},
+			setup:   func(ship *Miner, state *State) { ship.Nav.Status = client.DOCKED },
+			next:    SELL_REMAINING,
+			actions: []string{"sell"},
+			calls:   []string{"Sell ICE_WATER 20"},
+		},
+		{
+			name:    "failed sale is tried again",
+			state:   SELL_REMAINING,
+			cargo:   testCargo(20),
+			markets: map[string]client.Market{testStation: testBuyer},
+			setup:   failWith("Sell"),
+			next:    SELL_REMAINING,
+			actions: []string{"sell"},
+			calls:   []string{"Sell ICE_WATER 20"},
+			check: func(t *testing.T, ship *Miner, state *State) {
+				if ship.CargoUnits(string(client.TradeSymbolICEWATER)) != 20 {
+					t.Errorf("cargo changed by a failed sale: %+v", ship.Cargo)
+				}
+			},
+		},
+		{
+			name:    "nothing left to sell",
+			state:   SELL_REMAINING,
+			cargo:   testCargo(20),
+			markets: map[string]client.Market{testStation: testMarket},
+			setup:   func(ship *Miner, state *State) { ship.Nav.Status = client.DOCKED },
+			next:    ORBIT_STATION,
+			actions: []string{"undock"},
+			calls:   []string{"Orbit"},
+		},
+		{
+			name:    "travel to the field",
+			state:   START_TRAVEL,
+			cargo:   testCargo(0),
+			next:    IN_TRANSIT,
+			actions: []string{"navigate to field"},
+			calls:   []string{"Navigate " + testField},
+		},
+		{
+			name:  "already at the field",
+			state: START_TRAVEL,
+			cargo: testCargo(0),
+			setup: atField,
+			next:  IN_TRANSIT,
+		},
+		{
+			name:    "arrived at the field",
+			state:   IN_TRANSIT,
+			cargo:   testCargo(0),
+			setup:   func(ship *Miner, state *State) { ship.Nav.Status = client.INTRANSIT },
+			next:    ORBIT_ASTEROID,
+			actions: []string{"refresh"},
+			calls:   []string{"GetShipNav"},
+		},
+		{
+			name:    "failed refresh stays in transit",
+			state:   IN_TRANSIT,
+			cargo:   testCargo(0),
+			setup:   failWith("GetShipNav"),
+			next:    IN_TRANSIT,
+			actions: []string{"refresh"},
+			calls:   []string{"GetShipNav"},
+		},
+		{
+			name:    "survey",
+			state:   SURVEY,
+			cargo:   testCargo(0),
+			markets: map[string]client.Market{testStation: testPrices},
+			setup: func(ship *Miner, state *State) {
+				atField(ship, state)
+				state.ClaimSurveying(testField, ship.Symbol)
+			},
+			next:    ORBIT_ASTEROID,
+			actions: []string{"survey"},
+			calls:   []string{"Survey"},
+			check: func(t *testing.T, ship *Miner, state *State) {
+				if len(state.Surveys[testField]) != 1 || !state.CanClaimSurveying(testField, "TEST-2") {
+					t.Errorf("surveys %v and claims %v after surveying", state.Surveys, state.Surveying)
+				}
+			},
+		},
+		{
+			name:    "survey without a surveyor",
+			state:   SURVEY,
+			cargo:   testCargo(0),
+			markets: map[string]client.Market{testStation: testPrices},
+			setup: func(ship *Miner, state *State) {
+				atField(ship, state)
+				ship.Mounts = nil
+				state.ClaimSurveying(testField, ship.Symbol)
+			},
+			next:    ORBIT_ASTEROID,
+			actions: []string{"survey"},
+			calls:   []string{"Survey"},
+			check: func(t *testing.T, ship *Miner, state *State) {
+				if len(state.Surveys[testField]) != 0 || !state.CanClaimSurveying(testField, "TEST-2") {
+					t.Errorf("surveys %v and claims %v after a failed survey", state.Surveys, state.Surveying)
+				}
+			},
+		},
+		{
+			name:    "no survey and a surveyor",
+			state:   ORBIT_ASTEROID,
+			cargo:   testCargo(0),
+			markets: map[string]client.Market{testStation: testPrices},
+			setup:   atField,
+			next:    SURVEY,
+			actions: []string{"claim surveying"},
+		},
+		{
+			name:    "no survey and no surveyor",
+			state:   ORBIT_ASTEROID,
+			cargo:   testCargo(0),
+			markets: map[string]client.Market{testStation: testPrices},
+			setup: func(ship *Miner, state *State) {
+				atField(ship, state)
+				ship.Mounts = nil
+			},
+			next:    EXTRACT,
+			actions: []string{"target no survey"},
+		},
+		{
+			name:    "survey to mine",
+			state:   ORBIT_ASTEROID,
+			cargo:   testCargo(0),
+			markets: map[string]client.Market{testStation: testPrices},
+			setup: func(ship *Miner, state *State) {
+				atField(ship, state)
+				state.AddSurveys(testField, []client.Survey{testSurvey})
+			},
+			next:    EXTRACT,
+			actions: []string{"target best survey"},
+			check: func(t *testing.T, ship *Miner, state *State) {
+				if ship.Target == nil || ship.Target.Signature != testSurvey.Signature {
+					t.Errorf("target = %v, want %s", ship.Target, testSurvey.Signature)
+				}
+			},
+		},
+		{
+			name:  "full at the field",
+			state: ORBIT_ASTEROID,
+			cargo: testCargo(30),
+			setup: atField,
+			next:  FIND_SELL,
+		},
+		{
+			name:    "extract without a survey",
+			state:   EXTRACT,
+			cargo:   testCargo(0),
+			setup:   atField,
+			next:    ORBIT_ASTEROID,
+			actions: []string{"extract"},
+			calls:   []string{"Extract"},
+			check: func(t *testing.T, ship *Miner, state *State) {
+				if ship.Cargo.Units != 5 {
+					t.Errorf("%d units in the hold after extracting", ship.Cargo.Units)
+				}
+			},
+		},
+		{
+			name:  "full while extracting",
+			state: EXTRACT,
+			cargo: testCargo(30),
+			setup: atField,
+			next:  ORBIT_ASTEROID,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeClient{market: testMarket}
+			strategy := DefaultStrategy()
+			account := NewAccount(AgentConfig{Name: "test", Rate: 1000, Burst: 100}, &strategy)
+			account.Client = fake
+			c := clock.NewFake(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+			ship := &Miner{Ship: Ship{Clock: c, Account: account}, State: test.state}
+			ship.Symbol = "TEST-1"
+			ship.Nav = client.ShipNav{Status: client.INORBIT, SystemSymbol: testSystem, WaypointSymbol: testStation}
+			ship.Fuel = client.ShipFuel{Capacity: 100, Current: 100}
+			ship.Cargo = test.cargo
+			ship.Mounts = []client.ShipMount{{Symbol: client.MOUNTSURVEYORI}}
+			state := &State{Clock: c, Markets: map[string]client.Market{}, Surveys: map[string][]client.Survey{},
+				WaypointsBySystem: map[string][]client.ScannedWaypoint{}, Waypoints: map[string]client.ScannedWaypoint{}}
+			state.AddWaypoints(testSystem, []client.ScannedWaypoint{testMarketplace, testAsteroid})
+			for symbol, market := range test.markets {
+				state.Markets[symbol] = market
+			}
+			if test.setup != nil {
+				test.setup(ship, state)
+			}
+			fake.noSurveyor = !ship.CanSurvey()
+			ctx := MinerContext{Ship: ship, GameState: state}
+
+			transition, ok := MinerMachine.Decide(test.state, ctx)
+			if !ok {
+				t.Fatalf("no transition from %s", test.state)
+			}
+			actions := []string{}
+			for _, action := range transition.Actions {
+				actions = append(actions, action.Name)
+			}
+			if len(test.actions) > 0 || len(actions) > 0 {
+				if !reflect.DeepEqual(actions, test.actions) {
+					t.Errorf("actions = %v, want %v", actions, test.actions)
+				}
+			}
+			if next := MinerMachine.Step(test.state, ctx); next != test.next {
+				t.Errorf("next state = %s, want %s", next, test.next)
+			}
+			if len(test.calls) > 0 || len(fake.calls) > 0 {
+				if !reflect.DeepEqual(fake.calls, test.calls) {
+					t.Errorf("calls = %v, want %v", fake.calls, test.calls)
+				}
+			}
+			if test.check != nil {
+				test.check(t, ship, state)
+			}
+		})
+	}
+}
+
+// TestMachinesValidate checks the declared ship behaviour, which is otherwise
+// only validated when it is drawn.
+func TestMachinesValidate(t *testing.T) {
+	if err := MinerMachine.Validate(); err != nil {
+		t.Error(err)
+	}
+	if err := HaulerMachine.Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type NotificationKind string
+
+const (
+	NOTIFY_CONTRACT_FULFILLED NotificationKind = "CONTRACT_FULFILLED"
+	NOTIFY_CREDITS_THRESHOLD  NotificationKind = "CREDITS_THRESHOLD"
+	NOTIFY_SHIP_STUCK         NotificationKind = "SHIP_STUCK"
+	NOTIFY_SHIP_ERRORS        NotificationKind = "SHIP_ERRORS"
+	NOTIFY_SHIP_PURCHASED     NotificationKind = "SHIP_PURCHASED"
+)
+
+var notificationKinds = []NotificationKind{NOTIFY_CONTRACT_FULFILLED, NOTIFY_CREDITS_THRESHOLD, NOTIFY_SHIP_STUCK, NOTIFY_SHIP_ERRORS, NOTIFY_SHIP_PURCHASED}
+
+const (
+	// NOTIFY_QUEUE is how many notifications wait to be sent before new ones are dropped
+	NOTIFY_QUEUE          = 100
+	NOTIFY_CHECK_INTERVAL = time.Minute
+	WEBHOOK_TIMEOUT       = 10 * time.Second
+	DEFAULT_STUCK_AFTER   = 15 * time.Minute
+	DEFAULT_ERROR_COUNT   = 3
+	DEFAULT_ERROR_WINDOW  = 10 * time.Minute
+)
+
+// SinkConfig configures where notifications go: a webhook URL that receives
+// them as JSON, or a file they are appended to as JSON lines, - for stdout.
+type SinkConfig struct {
+	Webhook string `json:"webhook"`
+	File    string `json:"file"`
+}
+
+// NotificationRule configures a kind of notification. Thresholds are only used
+// by CREDITS_THRESHOLD, After by SHIP_STUCK and Count and Window by SHIP_ERRORS.
+type NotificationRule struct {
+	Sinks []string `json:"sinks"`
+	// MinInterval is the least time between two notifications of this kind
+	MinInterval Duration `json:"min_interval"`
+	Thresholds  []int    `json:"thresholds"`
+	After       Duration `json:"after"`
+	Count       int      `json:"count"`
+	Window      Duration `json:"window"`
+}
+
+type NotificationConfig struct {
+	Sinks  map[string]SinkConfig                 `json:"sinks"`
+	Events map[NotificationKind]NotificationRule `json:"events"`
+}
+
+func (config *NotificationConfig) Validate() error {
+	errs := []error{}
+	for _, name := range sortedKeys(config.Sinks) {
+		sink := config.Sinks[name]
+		if (sink.Webhook == "") == (sink.File == "") {
+			errs = append(errs, fmt.Errorf("notifications.sinks.%s: set either webhook or file", name))
+		}
+	}
+	for kind, rule := range config.Events {
+		known := false
+		for _, k := range notificationKinds {
+			known = known || k == kind
+		}
+		if !known {
+			errs = append(errs, fmt.Errorf("notifications.events: unknown event %s, use one of %v", kind, notificationKinds))
+		}
+		if len(rule.Sinks) == 0 {
+			errs = append(errs, fmt.Errorf("notifications.events.%s: no sinks", kind))
+		}
+		for _, sink := range rule.Sinks {
+			if _, ok := config.Sinks[sink]; !ok {
+				errs = append(errs, fmt.Errorf("notifications.events.%s: unknown sink %s", kind, sink))
+			}
+		}
+		if kind == NOTIFY_CREDITS_THRESHOLD && len(rule.Thresholds) == 0 {
+			errs = append(errs, fmt.Errorf("notifications.events.%s: no thresholds", kind))
+		}
+		if rule.MinInterval.Duration < 0 || rule.After.Duration < 0 || rule.Window.Duration < 0 || rule.Count < 0 {
+			errs = append(errs, fmt.Errorf("notifications.events.%s: durations and count must not be negative", kind))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Notification is what the sinks receive.
+type Notification struct {
+	Time    time.Time        `json:"time"`
+	Kind    NotificationKind `json:"kind"`
+	Agent   string           `json:"agent"`
+	Ship    string           `json:"ship,omitempty"`
+	Message string           `json:"message"`
+}
+
+type Sink interface {
+	Send(notification Notification) error
+}
+
+// WebhookSink posts every notification as JSON to a URL.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (sink *WebhookSink) Send(notification Notification) error {
+	b, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	resp, err := sink.Client.Post(sink.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s answered %s", sink.URL, resp.Status)
+	}
+	return nil
+}
+
+// WriterSink writes every notification as a line of JSON.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (sink *WriterSink) Send(notification Notification) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return json.NewEncoder(sink.w).Encode(notification)
+}
+
+func NewSink(config SinkConfig) (Sink, error) {
+	switch {
+	case config.Webhook != "":
+		return &WebhookSink{URL: config.Webhook, Client: &http.Client{Timeout: WEBHOOK_TIMEOUT}}, nil
+	case config.File == "-":
+		return &WriterSink{w: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterSink{w: f}, nil
+}
+
+// Notifier turns the events of all agents into notifications and sends them to
+// the configured sinks in the background, so a slow webhook does not hold up
+// the game.
+type Notifier struct {
+	mu       sync.Mutex
+	rules    map[NotificationKind]NotificationRule
+	sinks    map[string]Sink
+	limiters map[NotificationKind]*rate.Limiter
+	queue    chan Notification
+	// credits is the last known credits of each agent
+	credits map[string]int
+	// progress is when each ship, by agent and symbol, last got something done
+	progress map[[2]string]time.Time
+	stuck    map[[2]string]bool
+	errors   map[[2]string][]time.Time
+}
+
+func NewNotifier(config NotificationConfig) (*Notifier, error) {
+	notifier := &Notifier{
+		rules:    map[NotificationKind]NotificationRule{},
+		sinks:    map[string]Sink{},
+		limiters: map[NotificationKind]*rate.Limiter{},
+		queue:    make(chan Notification, NOTIFY_QUEUE),
+		credits:  map[string]int{},
+		progress: map[[2]string]time.Time{},
+		stuck:    map[[2]string]bool{},
+		errors:   map[[2]string][]time.Time{},
+	}
+	for name, sinkConfig := range config.Sinks {
+		sink, err := NewSink(sinkConfig)
+		if err != nil {
+			return nil, fmt.Errorf("notifications.sinks.%s: %w", name, err)
+		}
+		notifier.sinks[name] = sink
+	}
+	for kind, rule := range config.Events {
+		if rule.After.Duration == 0 {
+			rule.After.Duration = DEFAULT_STUCK_AFTER
+		}
+		if rule.Count == 0 {
+			rule.Count = DEFAULT_ERROR_COUNT
+		}
+		if rule.Window.Duration == 0 {
+			rule.Window.Duration = DEFAULT_ERROR_WINDOW
+		}
+		notifier.rules[kind] = rule
+		limit := rate.Inf
+		if rule.MinInterval.Duration > 0 {
+			limit = rate.Every(rule.MinInterval.Duration)
+		}
+		notifier.limiters[kind] = rate.NewLimiter(limit, 1)
+	}
+	return notifier, nil
+}
+
+// Handle is subscribed to the event bus of every agent.
+func (notifier *Notifier) Handle(event Event) {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	info := event.Info()
+	ship := [2]string{info.Agent, info.Ship}
+	// start the clock when a ship is first seen, or one that never gets
+	// anything done is never reported as stuck
+	if _, seen := notifier.progress[ship]; info.Ship != "" && !seen {
+		notifier.progress[ship] = info.Time
+	}
+	switch event := event.(type) {
+	case ContractUpdated:
+		if event.Change == CONTRACT_FULFILLED {
+			notifier.notify(NOTIFY_CONTRACT_FULFILLED, info, fmt.Sprintf("Fulfilled contract %s for %d credits", event.Contract.Id, event.Payment))
+		}
+	case ShipPurchased:
+		notifier.notify(NOTIFY_SHIP_PURCHASED, info, fmt.Sprintf("Bought ship %s (%s) for %d credits", event.NewShip.Symbol, event.NewShip.Registration.Role, event.Transaction.Price))
+	case CreditsChanged:
+		notifier.checkCredits(info, event.Credits)
+	case ShipError:
+		errs := append(notifier.errors[ship], info.Time)
+		rule := notifier.rules[NOTIFY_SHIP_ERRORS]
+		for len(errs) > 0 && info.Time.Sub(errs[0]) > rule.Window.Duration {
+			errs = errs[1:]
+		}
+		notifier.errors[ship] = errs
+		if rule.Count > 0 && len(errs) >= rule.Count {
+			notifier.notify(NOTIFY_SHIP_ERRORS, info, fmt.Sprintf("%s failed %d times in %s, last: %v", info.Ship, len(errs), rule.Window.Duration, event.Err))
+			notifier.errors[ship] = nil
+		}
+	// an idle ship waits for work on purpose, so it counts as getting something done
+	case ShipArrived, Extracted, SurveyCreated, Sold, Bought, Refueled, ShipIdle:
+		notifier.progress[ship] = info.Time
+		if notifier.stuck[ship] {
+			slog.Info("Ship is moving again", "agent", info.Agent, "ship", info.Ship)
+			delete(notifier.stuck, ship)
+		}
+	}
+}
+
+func (notifier *Notifier) checkCredits(info EventInfo, credits int) {
+	last, known := notifier.credits[info.Agent]
+	notifier.credits[info.Agent] = credits
+	if !known {
+		return
+	}
+	for _, threshold := range notifier.rules[NOTIFY_CREDITS_THRESHOLD].Thresholds {
+		if last < threshold && credits >= threshold {
+			notifier.notify(NOTIFY_CREDITS_THRESHOLD, info, fmt.Sprintf("Credits rose above %d to %d", threshold, credits))
+		} else if last >= threshold && credits < threshold {
+			notifier.notify(NOTIFY_CREDITS_THRESHOLD, info, fmt.Sprintf("Credits fell below %d to %d", threshold, credits))
+		}
+	}
+}
+
+// CheckStuck notifies about ships that got nothing done for longer than the
+// SHIP_STUCK rule allows, once until they get going again.
+func (notifier *Notifier) CheckStuck(now time.Time) {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	rule, ok := notifier.rules[NOTIFY_SHIP_STUCK]
+	if !ok {
+		return
+	}
+	for ship, last := range notifier.progress {
+		if notifier.stuck[ship] || now.Sub(last) < rule.After.Duration {
+			continue
+		}
+		notifier.stuck[ship] = true
+		info := EventInfo{Time: now, Agent: ship[0], Ship: ship[1]}
+		notifier.notify(NOTIFY_SHIP_STUCK, info, fmt.Sprintf("%s got nothing done since %s", ship[1], last.Format(time.RFC3339)))
+	}
+}
+
+// notify queues a notification if the kind is configured and not rate limited.
+func (notifier *Notifier) notify(kind NotificationKind, info EventInfo, message string) {
+	if _, ok := notifier.rules[kind]; !ok {
+		return
+	}
+	if !notifier.limiters[kind].Allow() {
+		slog.Debug("Notification rate limited", "kind", kind, "message", message)
+		return
+	}
+	notification := Notification{Time: info.Time, Kind: kind, Agent: info.Agent, Ship: info.Ship, Message: message}
+	select {
+	case notifier.queue <- notification:
+	default:
+		slog.Warn("Notification queue full, dropping notification", "kind", kind, "message", message)
+	}
+}
+
+// Run sends the queued notifications and checks for stuck ships.
+func (notifier *Notifier) Run() {
+	ticker := time.NewTicker(NOTIFY_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case notification := <-notifier.queue:
+			notifier.send(notification)
+		case now := <-ticker.C:
+			notifier.CheckStuck(now)
+		}
+	}
+}
+
+func (notifier *Notifier) send(notification Notification) {
+	for _, name := range notifier.rules[notification.Kind].Sinks {
+		if err := notifier.sinks[name].Send(notification); err != nil {
+			slog.Error("Failed to send notification", "sink", name, "kind", notification.Kind, "error", err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+	"github.com/Dutchy-/spacetrader-go/main/clock"
+)
+
+// webhookReceiver collects the notifications posted to it.
+type webhookReceiver struct {
+	mu            sync.Mutex
+	notifications []Notification
+	contentTypes  []string
+}
+
+func (receiver *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+	notification := Notification{}
+	if err := json.Unmarshal(b, &notification); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	receiver.notifications = append(receiver.notifications, notification)
+	receiver.contentTypes = append(receiver.contentTypes, r.Header.Get("Content-Type"))
+}
+
+// newTestNotifier returns a notifier with the rules that sends to a webhook
+// receiver.
+func newTestNotifier(t *testing.T, events map[NotificationKind]NotificationRule) (*Notifier, *webhookReceiver) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	for kind, rule := range events {
+		rule.Sinks = []string{"hook"}
+		events[kind] = rule
+	}
+	notifier, err := NewNotifier(NotificationConfig{Sinks: map[string]SinkConfig{"hook": {Webhook: server.URL}}, Events: events})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notifier, receiver
+}
+
+// flush sends the queued notifications, which Run does in the background.
+func (notifier *Notifier) flush() {
+	for len(notifier.queue) > 0 {
+		notifier.send(<-notifier.queue)
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	notifier, receiver := newTestNotifier(t, map[NotificationKind]NotificationRule{NOTIFY_CONTRACT_FULFILLED: {}})
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	notifier.Handle(ContractUpdated{EventInfo: EventInfo{Time: now, Agent: "AGENT", Ship: "AGENT-1"}, Contract: client.Contract{Id: "c1"}, Change: CONTRACT_FULFILLED, Payment: 5000})
+	notifier.flush()
+
+	if len(receiver.notifications) != 1 {
+		t.Fatalf("received %d notifications, want 1", len(receiver.notifications))
+	}
+	want := Notification{Time: now, Kind: NOTIFY_CONTRACT_FULFILLED, Agent: "AGENT", Ship: "AGENT-1", Message: "Fulfilled contract c1 for 5000 credits"}
+	if got := receiver.notifications[0]; got != want {
+		t.Errorf("received %+v, want %+v", got, want)
+	}
+	if receiver.contentTypes[0] != "application/json" {
+		t.Errorf("content type %q, want application/json", receiver.contentTypes[0])
+	}
+}
+
+func TestNotificationMinInterval(t *testing.T) {
+	notifier, receiver := newTestNotifier(t, map[NotificationKind]NotificationRule{
+		NOTIFY_CONTRACT_FULFILLED: {MinInterval: Duration{time.Hour}},
+		NOTIFY_SHIP_PURCHASED:     {},
+	})
+	info := EventInfo{Time: time.Now(), Agent: "AGENT"}
+	for i := 0; i < 3; i++ {
+		notifier.Handle(ContractUpdated{EventInfo: info, Change: CONTRACT_FULFILLED})
+		notifier.Handle(ShipPurchased{EventInfo: info})
+	}
+	notifier.flush()
+
+	count := map[NotificationKind]int{}
+	for _, notification := range receiver.notifications {
+		count[notification.Kind]++
+	}
+	if count[NOTIFY_CONTRACT_FULFILLED] != 1 {
+		t.Errorf("sent %d CONTRACT_FULFILLED within the min interval, want 1", count[NOTIFY_CONTRACT_FULFILLED])
+	}
+	// the limit is per kind
+	if count[NOTIFY_SHIP_PURCHASED] != 3 {
+		t.Errorf("sent %d SHIP_PURCHASED without a min interval, want 3", count[NOTIFY_SHIP_PURCHASED])
+	}
+}
+
+func TestShipErrorsCountAndWindow(t *testing.T) {
+	notifier, receiver := newTestNotifier(t, map[NotificationKind]NotificationRule{
+		NOTIFY_SHIP_ERRORS: {Count: 3, Window: Duration{10 * time.Minute}},
+	})
+	start := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	fail := func(after time.Duration) {
+		notifier.Handle(ShipError{EventInfo: EventInfo{Time: start.Add(after), Agent: "AGENT", Ship: "AGENT-1"}, Action: "extract", Err: errors.New("boom")})
+		notifier.flush()
+	}
+	// three errors, but never three within the window
+	fail(0)
+	fail(6 * time.Minute)
+	fail(12 * time.Minute)
+	if len(receiver.notifications) != 0 {
+		t.Fatalf("notified about errors spread over more than the window: %+v", receiver.notifications)
+	}
+	fail(13 * time.Minute)
+	if len(receiver.notifications) != 1 {
+		t.Fatalf("received %d notifications after three errors within the window, want 1", len(receiver.notifications))
+	}
+	if got := receiver.notifications[0]; got.Kind != NOTIFY_SHIP_ERRORS || got.Ship != "AGENT-1" {
+		t.Errorf("received %+v, want SHIP_ERRORS for AGENT-1", got)
+	}
+	// the count starts over after a notification
+	fail(14 * time.Minute)
+	if len(receiver.notifications) != 1 {
+		t.Errorf("notified again after one more error")
+	}
+}
+
+func TestShipStuck(t *testing.T) {
+	notifier, receiver := newTestNotifier(t, map[NotificationKind]NotificationRule{
+		NOTIFY_SHIP_STUCK: {After: Duration{15 * time.Minute}},
+	})
+	start := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	// the ship never gets anything done, it only fails
+	notifier.Handle(ShipError{EventInfo: EventInfo{Time: start, Agent: "AGENT", Ship: "AGENT-1"}, Action: "navigate", Err: errors.New("boom")})
+	notifier.CheckStuck(start.Add(10 * time.Minute))
+	notifier.flush()
+	if len(receiver.notifications) != 0 {
+		t.Fatalf("notified before the ship was stuck for long: %+v", receiver.notifications)
+	}
+	notifier.CheckStuck(start.Add(20 * time.Minute))
+	notifier.CheckStuck(start.Add(30 * time.Minute))
+	notifier.flush()
+	if len(receiver.notifications) != 1 || receiver.notifications[0].Kind != NOTIFY_SHIP_STUCK {
+		t.Fatalf("received %+v, want one SHIP_STUCK", receiver.notifications)
+	}
+}
+
+// TestIdleHaulerNotStuck runs a hauler without work for longer than a ship may
+// get nothing done, which is not being stuck.
+func TestIdleHaulerNotStuck(t *testing.T) {
+	notifier, receiver := newTestNotifier(t, map[NotificationKind]NotificationRule{
+		NOTIFY_SHIP_STUCK: {After: Duration{15 * time.Minute}},
+	})
+	fake := clock.NewFake(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+	strategy := DefaultStrategy()
+	account := NewAccount(AgentConfig{Name: "AGENT", Rate: 1000, Burst: 100}, &strategy)
+	account.Events.Subscribe(notifier.Handle)
+	hauler := &Hauler{Ship: Ship{Clock: fake, Account: account}}
+	hauler.Symbol = "AGENT-1"
+	hauler.Nav = client.ShipNav{Status: client.DOCKED, SystemSymbol: testSystem, WaypointSymbol: testStation}
+	state := &State{Clock: fake, WaypointsBySystem: map[string][]client.ScannedWaypoint{testSystem: {testMarketplace}}}
+	for fake.Now().Before(time.Date(2023, 6, 1, 1, 0, 0, 0, time.UTC)) {
+		hauler.Run(state)
+		fake.Advance(hauler.ReadyAt().Sub(fake.Now()))
+		notifier.CheckStuck(fake.Now())
+	}
+	notifier.flush()
+	if hauler.State != HAULER_IDLE || len(receiver.notifications) != 0 {
+		t.Errorf("hauler in %s received %+v, want it idle without notifications", hauler.State, receiver.notifications)
+	}
+}
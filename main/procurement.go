@@ -0,0 +1,204 @@
+package main
+
+import (
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+type ProcurementState string
+
+const (
+	PROCURE_TRAVEL_MARKET   ProcurementState = "PROCURE_TRAVEL_MARKET"
+	PROCURE_BUY             ProcurementState = "PROCURE_BUY"
+	PROCURE_TRAVEL_DELIVERY ProcurementState = "PROCURE_TRAVEL_DELIVERY"
+	PROCURE_DELIVER         ProcurementState = "PROCURE_DELIVER"
+	PROCURE_DONE            ProcurementState = "PROCURE_DONE"
+)
+
+// Procurement is an order to buy a good at a market and deliver it to a
+// contract, or without a contract to sell it at the destination.
+type Procurement struct {
+	ContractId  string           `json:"contractId"`
+	TradeSymbol string           `json:"tradeSymbol"`
+	Market      string           `json:"market"`
+	Destination string           `json:"destination"`
+	Units       int              `json:"units"`
+	Delivered   int              `json:"delivered"`
+	State       ProcurementState `json:"state"`
+}
+
+// BestPurchase returns the market with the lowest known purchase price for a good,
+// and that price, ignoring the markets skip returns true for. The price is 0 if
+// no market is known to sell the good.
+func (state *State) BestPurchase(good string, skip func(market string) bool) (string, int) {
+	bestMarket := ""
+	bestPrice := 0
+	for symbol, market := range state.Markets {
+		if market.TradeGoods == nil || skip(symbol) {
+			continue
+		}
+		for _, tg := range *market.TradeGoods {
+			if tg.Symbol == good && tg.PurchasePrice > 0 && (bestPrice == 0 || tg.PurchasePrice < bestPrice) {
+				bestMarket = symbol
+				bestPrice = tg.PurchasePrice
+			}
+		}
+	}
+	return bestMarket, bestPrice
+}
+
+// MarketTradeGood returns the trade good as last seen at the market, if any.
+func (state *State) MarketTradeGood(marketSymbol string, good string) *client.MarketTradeGood {
+	market, ok := state.Markets[marketSymbol]
+	if !ok || market.TradeGoods == nil {
+		return nil
+	}
+	for _, tg := range *market.TradeGoods {
+		if tg.Symbol == good {
+			return &tg
+		}
+	}
+	return nil
+}
+
+// RunProcurement takes a single step in carrying out the order. The ship travels
+// to the market, buys as much as fits, and delivers it, until all units have been
+// delivered. It returns true once the order is done.
+func (ship *Ship) RunProcurement(order *Procurement, gameState *State) bool {
+	if order.State == "" {
+		order.State = PROCURE_TRAVEL_MARKET
+	}
+	logger := ship.Log().With("order_state", order.State, "good", order.TradeSymbol, "contract", order.ContractId)
+	logger.Info("Procuring", "units", order.Units-order.Delivered)
+	switch order.State {
+	case PROCURE_TRAVEL_MARKET:
+		moved, err := ship.MoveTo(order.Market)
+		if err != nil {
+			logger.Warn("Failed to move to the market, trying again", "market", order.Market, "error", err)
+		}
+		if moved {
+			order.State = PROCURE_BUY
+		}
+	case PROCURE_BUY:
+		if ship.HasLowFuel(ship.Account.Strategy.Hauler.LowFuel) {
+			if agent, _, err := ship.Refuel(); err != nil {
+				logger.Error("Failed to refuel", "error", err)
+			} else {
+				gameState.Agent = agent
+			}
+		}
+		units := order.Units - order.Delivered - ship.CargoUnits(order.TradeSymbol)
+		if free := ship.Cargo.Capacity - ship.Cargo.Units; units > free {
+			units = free
+		}
+		if tg := gameState.MarketTradeGood(order.Market, order.TradeSymbol); tg != nil && tg.TradeVolume > 0 && units > tg.TradeVolume {
+			units = tg.TradeVolume
+		}
+		if units <= 0 {
+			if ship.CargoUnits(order.TradeSymbol) == 0 {
+				logger.Warn("No room to procure, giving up")
+				order.State = PROCURE_DONE
+			} else {
+				order.State = PROCURE_TRAVEL_DELIVERY
+			}
+			break
+		}
+		agent, trans, err := ship.Purchase(client.TradeSymbol(order.TradeSymbol), units)
+		if err != nil {
+			logger.Error("Failed to buy", "error", err)
+			ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "buy", Err: err})
+			if ship.CargoUnits(order.TradeSymbol) > 0 {
+				// deliver what was bought before giving up on the rest
+				order.State = PROCURE_TRAVEL_DELIVERY
+			} else {
+				order.State = PROCURE_DONE
+			}
+			break
+		}
+		gameState.Agent = agent
+		logger.Info("Bought", "units", trans.Units, "price", trans.TotalPrice, "credits", agent.Credits)
+	case PROCURE_TRAVEL_DELIVERY:
+		moved, err := ship.MoveTo(order.Destination)
+		if err != nil {
+			logger.Warn("Failed to move to the destination, trying again", "destination", order.Destination, "error", err)
+		}
+		if moved {
+			order.State = PROCURE_DELIVER
+		}
+	case PROCURE_DELIVER:
+		units := ship.CargoUnits(order.TradeSymbol)
+		if remaining := order.Units - order.Delivered; units > remaining {
+			units = remaining
+		}
+		if units <= 0 {
+			logger.Warn("Nothing to deliver")
+			if order.ContractId == "" || order.Delivered >= order.Units {
+				order.State = PROCURE_DONE
+			} else {
+				order.State = PROCURE_TRAVEL_MARKET
+			}
+			break
+		}
+		if order.ContractId == "" {
+			agent, _, err := ship.SellCargo(order.TradeSymbol, units)
+			if err != nil {
+				logger.Error("Failed to sell traded goods", "error", err)
+				ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "sell", Err: err})
+			} else {
+				gameState.Agent = agent
+				logger.Info("Sold traded goods", "units", units, "good", order.TradeSymbol, "market", order.Destination)
+			}
+			order.State = PROCURE_DONE
+			break
+		}
+		contract, err := ship.DeliverContract(order.ContractId, order.TradeSymbol, units)
+		if err != nil {
+			logger.Error("Failed to deliver", "error", err)
+			ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "deliver", Err: err})
+			order.State = PROCURE_DONE
+			break
+		}
+		gameState.UpdateContract(contract)
+		order.Delivered += units
+		logger.Info("Delivered procured goods", "units", units, "delivered", order.Delivered, "ordered", order.Units)
+		if order.Delivered >= order.Units {
+			order.State = PROCURE_DONE
+		} else {
+			order.State = PROCURE_TRAVEL_MARKET
+		}
+	}
+	return order.State == PROCURE_DONE
+}
+
+// PlanProcurement compares the payment per unit of the active contract with the
+// cheapest known purchase price of every good it still needs, and when buying is
+// cheaper it queues a task to buy and deliver the goods.
+func (game *Game) PlanProcurement() {
+	contract := game.State.ActiveContract()
+	if contract == nil || contract.Type != client.ContractTypePROCUREMENT {
+		return
+	}
+	required := 0
+	for _, deliver := range *contract.Terms.Deliver {
+		required += deliver.UnitsRequired
+	}
+	if required == 0 {
+		return
+	}
+	perUnit := (contract.Terms.Payment.OnAccepted + contract.Terms.Payment.OnFulfilled) / required
+	for _, deliver := range RemainingDeliveries(*contract) {
+		market, price := game.State.BestPurchase(deliver.TradeSymbol, game.Account.Strategy.IsBlacklisted)
+		if price == 0 || price >= perUnit {
+			continue
+		}
+		order := &Procurement{
+			ContractId:  contract.Id,
+			TradeSymbol: deliver.TradeSymbol,
+			Market:      market,
+			Destination: deliver.DestinationSymbol,
+			Units:       deliver.UnitsRequired - deliver.UnitsFulfilled,
+		}
+		if game.Tasks.Add(&Task{Kind: TASK_DELIVER, Order: order}) {
+			game.Log().Info("Planned procurement instead of mining", "units", order.Units, "good", order.TradeSymbol, "market", market, "price", price, "payment_per_unit", perUnit)
+		}
+	}
+}
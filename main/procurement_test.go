@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+	"github.com/Dutchy-/spacetrader-go/main/clock"
+)
+
+// procurementClient is a market and contract that trade with the ship, keeping
+// its hold up to date. Buying fails when failBuy is set.
+type procurementClient struct {
+	client.ClientWithResponsesInterface
+	calls   []string
+	cargo   client.ShipCargo
+	failBuy bool
+}
+
+func (f *procurementClient) hold(good string, units int) {
+	f.cargo = cargoWith(good, f.cargo.Units+units)
+}
+
+func (f *procurementClient) PurchaseCargoWithResponse(ctx context.Context, shipSymbol string, body client.PurchaseCargoJSONRequestBody, reqEditors ...client.RequestEditorFn) (*client.PurchaseCargoResponse, error) {
+	f.calls = append(f.calls, fmt.Sprintf("Purchase %s %d", body.Symbol, body.Units))
+	if f.failBuy {
+		return client.ParsePurchaseCargoResponse(apiResponse(400, "Agent has insufficient funds."))
+	}
+	f.hold(body.Symbol, body.Units)
+	transaction := client.MarketTransaction{TradeSymbol: body.Symbol, Units: body.Units, TotalPrice: body.Units * 10, Type: client.PURCHASE}
+	return client.ParsePurchaseCargoResponse(apiResponse(201, map[string]any{"agent": client.Agent{Credits: 1000}, "cargo": f.cargo, "transaction": transaction}))
+}
+
+func (f *procurementClient) SellCargoWithResponse(ctx context.Context, shipSymbol string, body client.SellCargoJSONRequestBody, reqEditors ...client.RequestEditorFn) (*client.SellCargoResponse, error) {
+	f.calls = append(f.calls, fmt.Sprintf("Sell %s %d", body.Symbol, body.Units))
+	f.hold(body.Symbol, -body.Units)
+	transaction := client.MarketTransaction{TradeSymbol: body.Symbol, Units: body.Units, TotalPrice: body.Units * 20, Type: client.SELL}
+	return client.ParseSellCargoResponse(apiResponse(201, map[string]any{"agent": client.Agent{Credits: 2000}, "cargo": f.cargo, "transaction": transaction}))
+}
+
+func (f *procurementClient) DeliverContractWithResponse(ctx context.Context, contractId string, body client.DeliverContractJSONRequestBody, reqEditors ...client.RequestEditorFn) (*client.DeliverContractResponse, error) {
+	f.calls = append(f.calls, fmt.Sprintf("Deliver %s %d", body.TradeSymbol, body.Units))
+	f.hold(body.TradeSymbol, -body.Units)
+	return client.ParseDeliverContractResponse(apiResponse(200, map[string]any{"cargo": f.cargo, "contract": client.Contract{Id: contractId}}))
+}
+
+func TestRunProcurement(t *testing.T) {
+	const (
+		good        = "IRON_ORE"
+		market      = "X1-TEST-A1"
+		destination = "X1-TEST-B1"
+	)
+	contract := Procurement{ContractId: "c1", TradeSymbol: good, Market: market, Destination: destination, Units: 50}
+	trade := Procurement{TradeSymbol: good, Market: market, Destination: destination, Units: 20}
+	in := func(order Procurement, state ProcurementState, delivered int) Procurement {
+		order.State = state
+		order.Delivered = delivered
+		return order
+	}
+	tests := []struct {
+		name    string
+		order   Procurement
+		at      string
+		held    int
+		failBuy bool
+		// next is the state of the order afterwards
+		next      ProcurementState
+		calls     []string
+		heldAfter int
+		delivered int
+	}{
+		{name: "arrive at the market", order: contract, at: market, next: PROCURE_BUY},
+		{name: "buy what fits", order: in(contract, PROCURE_BUY, 0), at: market, next: PROCURE_BUY, calls: []string{"Purchase IRON_ORE 30"}, heldAfter: 30},
+		{name: "buy what is still needed", order: in(contract, PROCURE_BUY, 40), at: market, next: PROCURE_BUY, calls: []string{"Purchase IRON_ORE 10"}, heldAfter: 10, delivered: 40},
+		{name: "full hold goes to deliver", order: in(contract, PROCURE_BUY, 0), at: market, held: 30, next: PROCURE_TRAVEL_DELIVERY, heldAfter: 30},
+		{name: "failed buy with nothing held gives up", order: in(contract, PROCURE_BUY, 0), at: market, failBuy: true, next: PROCURE_DONE, calls: []string{"Purchase IRON_ORE 30"}},
+		{name: "failed buy delivers what is held", order: in(contract, PROCURE_BUY, 0), at: market, held: 10, failBuy: true, next: PROCURE_TRAVEL_DELIVERY, calls: []string{"Purchase IRON_ORE 20"}, heldAfter: 10},
+		{name: "arrive at the destination", order: in(contract, PROCURE_TRAVEL_DELIVERY, 0), at: destination, held: 30, next: PROCURE_DELIVER, heldAfter: 30},
+		{name: "deliver and buy more", order: in(contract, PROCURE_DELIVER, 0), at: destination, held: 30, next: PROCURE_TRAVEL_MARKET, calls: []string{"Deliver IRON_ORE 30"}, delivered: 30},
+		{name: "deliver the rest", order: in(contract, PROCURE_DELIVER, 40), at: destination, held: 10, next: PROCURE_DONE, calls: []string{"Deliver IRON_ORE 10"}, delivered: 50},
+		{name: "nothing to deliver buys more", order: in(contract, PROCURE_DELIVER, 30), at: destination, next: PROCURE_TRAVEL_MARKET, delivered: 30},
+		{name: "sell a trade", order: in(trade, PROCURE_DELIVER, 0), at: destination, held: 20, next: PROCURE_DONE, calls: []string{"Sell IRON_ORE 20"}},
+		{name: "nothing to sell ends a trade", order: in(trade, PROCURE_DELIVER, 0), at: destination, next: PROCURE_DONE},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &procurementClient{cargo: cargoWith(good, test.held), failBuy: test.failBuy}
+			strategy := DefaultStrategy()
+			account := NewAccount(AgentConfig{Name: "test", Rate: 1000, Burst: 100}, &strategy)
+			account.Client = fake
+			c := clock.NewFake(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+			ship := &Ship{Clock: c, Account: account}
+			ship.Symbol = "TEST-1"
+			ship.Nav = client.ShipNav{Status: client.DOCKED, SystemSymbol: "X1-TEST", WaypointSymbol: test.at}
+			ship.Fuel = client.ShipFuel{Capacity: 100, Current: 100}
+			ship.Cargo = fake.cargo
+			state := &State{Clock: c, Markets: map[string]client.Market{}}
+			order := test.order
+
+			done := ship.RunProcurement(&order, state)
+			if order.State != test.next || done != (test.next == PROCURE_DONE) {
+				t.Errorf("order state = %s, done %v, want %s", order.State, done, test.next)
+			}
+			if len(fake.calls) > 0 || len(test.calls) > 0 {
+				if !reflect.DeepEqual(fake.calls, test.calls) {
+					t.Errorf("calls = %v, want %v", fake.calls, test.calls)
+				}
+			}
+			if held := ship.CargoUnits(good); held != test.heldAfter {
+				t.Errorf("holds %d units, want %d", held, test.heldAfter)
+			}
+			if order.Delivered != test.delivered {
+				t.Errorf("delivered %d, want %d", order.Delivered, test.delivered)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+// DEFAULT_RECONCILE_INTERVAL is by default how often every ship is compared with the server
+const DEFAULT_RECONCILE_INTERVAL = 5 * time.Minute
+
+// cargoActions are the actions whose failure only leaves the cargo in doubt.
+var cargoActions = map[string]bool{"buy": true, "sell": true, "deliver": true, "jettison": true}
+
+// ShipChange is a field in which our model of a ship differed from the server.
+type ShipChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// diverges reports whether the change invalidates the state the ship's state
+// machine is in, which is derived from where the ship is and what it carries.
+func (change ShipChange) diverges() bool {
+	return strings.HasPrefix(change.Field, "nav.") || strings.HasPrefix(change.Field, "cargo.")
+}
+
+// DiffShip lists the fields that differ between two versions of a value, named
+// by their JSON path below prefix, e.g. nav.status.
+func DiffShip(prefix string, old any, new any) []ShipChange {
+	changes := []ShipChange{}
+	diffJSON(prefix, old, new, func(field, before, after string) {
+		changes = append(changes, ShipChange{Field: strings.TrimPrefix(field, "."), Old: before, New: after})
+	})
+	return changes
+}
+
+// Reconcile replaces our model of the ship with the one on the server and
+// returns where they differed.
+func (ship *Ship) Reconcile() ([]ShipChange, error) {
+	resp, err := ship.Account.Client.GetMyShipWithResponse(context.TODO(), ship.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != 200 {
+		return nil, ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	changes := DiffShip("", ship.Ship, resp.JSON200.Data)
+	arrived := ship.Nav.Status == client.INTRANSIT && resp.JSON200.Data.Nav.Status != client.INTRANSIT
+	ship.Ship = resp.JSON200.Data
+	if arrived {
+		ship.Account.Events.Publish(ShipArrived{EventInfo: ship.event(), Waypoint: ship.Nav.WaypointSymbol})
+	}
+	return changes, nil
+}
+
+// ReconcileCargo is Reconcile for just the cargo hold.
+func (ship *Ship) ReconcileCargo() ([]ShipChange, error) {
+	resp, err := ship.Account.Client.GetMyShipCargoWithResponse(context.TODO(), ship.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != 200 {
+		return nil, ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	changes := DiffShip(".cargo", ship.Cargo, resp.JSON200.Data)
+	ship.Cargo = resp.JSON200.Data
+	return changes, nil
+}
+
+// ReconcileShips compares the ships that had an error since the last time with
+// the server, or with all set every ship. Differences are logged, and ships
+// whose location or cargo differed start over in the state that matches. A
+// failed ship that diverged also gives back its task, unless it carries goods
+// for it, so the dispatcher hands it to the best ship from where they really are.
+func (game *Game) ReconcileShips(all bool) {
+	for _, ship := range game.State.Ships {
+		s := baseShip(ship)
+		action, failed := game.reconcile[s.Symbol]
+		if !all && !failed {
+			continue
+		}
+		var changes []ShipChange
+		var err error
+		if !all && cargoActions[action] {
+			changes, err = s.ReconcileCargo()
+		} else {
+			changes, err = s.Reconcile()
+		}
+		if err != nil {
+			game.Log().Error("Failed to reconcile ship", "ship", s.Symbol, "error", err)
+			continue
+		}
+		diverged := false
+		for _, change := range changes {
+			game.Log().Info("Ship differed from the server", "ship", s.Symbol, "field", change.Field, "ours", change.Old, "server", change.New)
+			diverged = diverged || change.diverges()
+		}
+		if !diverged {
+			continue
+		}
+		if task := s.Task; failed && task != nil && !task.Standing && (task.Order == nil || s.CargoUnits(task.Order.TradeSymbol) == 0) {
+			game.Log().Warn("Releasing the task of a failed ship", "ship", s.Symbol, "task", task.String())
+			s.DropTask()
+		}
+		var from, to string
+		switch ship := ship.(type) {
+		case *Miner:
+			from = string(ship.State)
+			ship.InitState()
+			to = string(ship.State)
+		case *Hauler:
+			from = string(ship.State)
+			ship.InitState()
+			to = string(ship.State)
+		}
+		game.Log().Warn("Ship diverged from the server, restarting its state machine", "ship", s.Symbol, "from", from, "to", to)
+	}
+	game.reconcile = map[string]string{}
+}
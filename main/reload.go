@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	CONFIG_POLL_INTERVAL = 5 * time.Second
+	// CONFIG_AUDIT_SUFFIX is appended to the config file name for the log of strategy changes
+	CONFIG_AUDIT_SUFFIX = ".audit.log"
+)
+
+// ConfigChange is a line in the audit log: a single strategy setting that changed.
+type ConfigChange struct {
+	Time  time.Time `json:"time"`
+	Field string    `json:"field"`
+	Old   string    `json:"old"`
+	New   string    `json:"new"`
+}
+
+// ConfigWatcher polls the config file and publishes the strategy in it whenever
+// the file changes. The games pick up the new strategy at their next decision.
+type ConfigWatcher struct {
+	path      string
+	auditPath string
+	// prepare applies the environment and flag overrides to a freshly loaded config
+	prepare  func(config *Config) error
+	config   Config
+	modTime  time.Time
+	strategy atomic.Pointer[Strategy]
+}
+
+func NewConfigWatcher(path string, config Config, prepare func(config *Config) error) *ConfigWatcher {
+	watcher := &ConfigWatcher{
+		path:      path,
+		auditPath: path + CONFIG_AUDIT_SUFFIX,
+		prepare:   prepare,
+		config:    config,
+	}
+	if info, err := os.Stat(path); err == nil {
+		watcher.modTime = info.ModTime()
+	}
+	watcher.strategy.Store(&config.Strategy)
+	return watcher
+}
+
+// Strategy returns the latest valid strategy.
+func (watcher *ConfigWatcher) Strategy() *Strategy {
+	return watcher.strategy.Load()
+}
+
+func (watcher *ConfigWatcher) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := watcher.Check(); err != nil {
+			slog.Error("Not reloading config, keeping the current strategy", "path", watcher.path, "error", err)
+		}
+	}
+}
+
+// Check reloads the config file if it was modified since it was last read. An
+// invalid config is reported and ignored.
+func (watcher *ConfigWatcher) Check() error {
+	info, err := os.Stat(watcher.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(watcher.modTime) {
+		return nil
+	}
+	watcher.modTime = info.ModTime()
+	config, err := LoadConfig(watcher.path)
+	if err != nil {
+		return err
+	}
+	if err := watcher.prepare(&config); err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	old := watcher.config
+	watcher.config = config
+	restart := old
+	restart.Strategy = config.Strategy
+	if !reflect.DeepEqual(restart, config) {
+		slog.Warn("Config changes outside the strategy only take effect after a restart", "path", watcher.path)
+	}
+	changes := DiffStrategy(old.Strategy, config.Strategy, time.Now())
+	if len(changes) == 0 {
+		return nil
+	}
+	for _, change := range changes {
+		slog.Info("Strategy changed", "field", change.Field, "old", change.Old, "new", change.New)
+	}
+	if err := watcher.audit(changes); err != nil {
+		slog.Error("Failed to write config audit log", "path", watcher.auditPath, "error", err)
+	}
+	strategy := config.Strategy
+	watcher.strategy.Store(&strategy)
+	return nil
+}
+
+func (watcher *ConfigWatcher) audit(changes []ConfigChange) error {
+	f, err := os.OpenFile(watcher.auditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	for _, change := range changes {
+		if err := encoder.Encode(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiffStrategy lists the settings that differ between two strategies, named by
+// their path in the config file, e.g. strategy.miner.low_fuel.
+func DiffStrategy(old Strategy, new Strategy, t time.Time) []ConfigChange {
+	changes := []ConfigChange{}
+	diffJSON("strategy", old, new, func(field, before, after string) {
+		changes = append(changes, ConfigChange{Time: t, Field: field, Old: before, New: after})
+	})
+	return changes
+}
+
+// diffJSON calls change for every leaf of the JSON form of old and new that
+// differs, in order of their dotted path below prefix. A leaf that is gone
+// changes to "".
+func diffJSON(prefix string, old any, new any, change func(field, before, after string)) {
+	before, after := map[string]string{}, map[string]string{}
+	flatten(prefix, old, before)
+	flatten(prefix, new, after)
+	for field := range before {
+		if _, ok := after[field]; !ok {
+			after[field] = ""
+		}
+	}
+	for _, field := range sortedKeys(after) {
+		if before[field] != after[field] {
+			change(field, before[field], after[field])
+		}
+	}
+}
+
+// flatten stores every leaf of the JSON form of v under its dotted path. Lists
+// are leaves, so a changed blacklist shows up as one change.
+func flatten(prefix string, v any, out map[string]string) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		out[prefix] = fmt.Sprint(v)
+		return
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(b, &fields) != nil {
+		var s string
+		if json.Unmarshal(b, &s) == nil {
+			out[prefix] = s
+		} else {
+			out[prefix] = string(b)
+		}
+		return
+	}
+	for name, raw := range fields {
+		flatten(prefix+"."+name, raw, out)
+	}
+}
+
+// ApplyStrategy switches the game to the latest strategy from the config file and
+// moves ships whose role assignment changed to their new role.
+func (game *Game) ApplyStrategy() {
+	strategy := game.Account.CurrentStrategy()
+	if strategy == game.Account.Strategy {
+		return
+	}
+	game.Account.Strategy = strategy
+	game.AssignRoles()
+}
+
+// AssignRoles rewraps every ship whose type does not match its role in the
+// strategy. A task moves along with the ship if the new role can do it.
+func (game *Game) AssignRoles() {
+	for i, ship := range game.State.Ships {
+		var reassigned BaseShip
+		switch ship := ship.(type) {
+		case *Miner:
+			if game.Account.Strategy.ShipRole(ship.Ship.Ship) == ROLE_HAULER {
+				game.State.ReleaseSurveying(ship.Nav.WaypointSymbol, ship.Symbol)
+				reassigned = &Hauler{Ship: ship.Ship}
+			}
+		case *Hauler:
+			if game.Account.Strategy.ShipRole(ship.Ship.Ship) == ROLE_MINER {
+				reassigned = &Miner{Ship: ship.Ship}
+			}
+		}
+		if reassigned == nil {
+			continue
+		}
+		s := baseShip(reassigned)
+		if s.Task != nil && !CanTake(reassigned, s.Task) {
+			s.DropTask()
+		}
+		game.State.Ships[i] = reassigned
+		game.Log().Info("Reassigned ship", "ship", s.Symbol, "role", game.Account.Strategy.ShipRole(s.Ship))
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffStrategy(t *testing.T) {
+	at := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	old := DefaultStrategy()
+	old.Roles = map[string]string{"TEST-1": ROLE_HAULER, "TEST-2": ROLE_MINER}
+	tests := []struct {
+		name   string
+		change func(strategy *Strategy)
+		want   []ConfigChange
+	}{
+		{"nothing changed", func(strategy *Strategy) {}, []ConfigChange{}},
+		{
+			name:   "changed field",
+			change: func(strategy *Strategy) { strategy.Miner.LowFuel = 0.3 },
+			want:   []ConfigChange{{Time: at, Field: "strategy.miner.low_fuel", Old: "0.25", New: "0.3"}},
+		},
+		{
+			name:   "changed duration",
+			change: func(strategy *Strategy) { strategy.PollInterval = Duration{time.Second} },
+			want:   []ConfigChange{{Time: at, Field: "strategy.poll_interval", Old: "500ms", New: "1s"}},
+		},
+		{
+			name:   "removed field",
+			change: func(strategy *Strategy) { strategy.Roles = map[string]string{"TEST-2": ROLE_MINER} },
+			want:   []ConfigChange{{Time: at, Field: "strategy.roles.TEST-1", Old: ROLE_HAULER, New: ""}},
+		},
+		{
+			name: "added field",
+			change: func(strategy *Strategy) {
+				strategy.Roles = map[string]string{"TEST-1": ROLE_HAULER, "TEST-2": ROLE_MINER, "TEST-3": ROLE_HAULER}
+			},
+			want: []ConfigChange{{Time: at, Field: "strategy.roles.TEST-3", Old: "", New: ROLE_HAULER}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			new := old
+			test.change(&new)
+			if got := DiffStrategy(old, new, at); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("DiffStrategy() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// writeConfig writes the config file with a modification time that differs
+// from the last one, so the watcher sees every write.
+func writeConfig(t *testing.T, path string, config string, modTime time.Time) {
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readAudit returns the changes in the audit log.
+func readAudit(t *testing.T, path string) []ConfigChange {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	changes := []ConfigChange{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		change := ConfigChange{}
+		if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+			t.Fatal(err)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func TestConfigWatcherCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	modTime := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	writeConfig(t, path, `{"strategy": {"miner": {"low_fuel": 0.5}}}`, modTime)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher := NewConfigWatcher(path, config, func(config *Config) error { return nil })
+
+	// an unchanged file is not read again
+	if err := watcher.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if readAudit(t, watcher.auditPath) != nil {
+		t.Fatal("audit log written without a change")
+	}
+
+	writeConfig(t, path, `{"strategy": {"miner": {"low_fuel": 0.3}}}`, modTime.Add(time.Second))
+	if err := watcher.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if low := watcher.Strategy().Miner.LowFuel; low != 0.3 {
+		t.Errorf("low fuel %v after reloading, want 0.3", low)
+	}
+	audit := readAudit(t, watcher.auditPath)
+	if len(audit) != 1 || audit[0].Field != "strategy.miner.low_fuel" || audit[0].Old != "0.5" || audit[0].New != "0.3" {
+		t.Errorf("audit log = %+v, want the low fuel change from 0.5 to 0.3", audit)
+	}
+
+	// an invalid config is rejected and the running strategy kept
+	running := watcher.Strategy()
+	writeConfig(t, path, `{"strategy": {"miner": {"low_fuel": 0.1}, "poll_interval": "-1s"}}`, modTime.Add(2*time.Second))
+	if err := watcher.Check(); err == nil {
+		t.Error("invalid config accepted")
+	}
+	if watcher.Strategy() != running || running.Miner.LowFuel != 0.3 {
+		t.Errorf("strategy changed to %+v by an invalid config", watcher.Strategy())
+	}
+	if audit := readAudit(t, watcher.auditPath); len(audit) != 1 {
+		t.Errorf("audit log = %+v after an invalid config, want only the first change", audit)
+	}
+}
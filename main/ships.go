@@ -2,18 +2,28 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/Dutchy-/spacetrader-go/client"
+	"github.com/Dutchy-/spacetrader-go/main/clock"
 	intersect "github.com/juliangruber/go-intersect"
 )
 
 type Ship struct {
 	client.Ship
+	// Cooldown is the reactor cooldown, which follows extracting, surveying and
+	// scanning. The arrival after navigating is in the nav route.
 	Cooldown client.Cooldown
+	// Wait is until when the ship has nothing to do
+	Wait  time.Time   `json:"-"`
+	Clock clock.Clock `json:"-"`
+	// Account is the agent the ship belongs to
+	Account *Account `json:"-"`
+	// Task is what the dispatcher assigned the ship to do, if anything
+	Task *Task `json:"-"`
 }
 
 type Miner struct {
@@ -21,23 +31,27 @@ type Miner struct {
 	State    MinerState
 	Contract client.Contract
 	// OreType  client.TradeSymbol
-	Target client.Survey
+	// Target is the survey to extract from, or nil to extract without a survey
+	Target *client.Survey
 }
 
 type BaseShip interface {
-	GoTo(waypoint *client.Waypoint)
-	GoToSymbol(dest string)
+	GoTo(waypoint *client.Waypoint) error
+	GoToSymbol(dest string) error
 	Status() client.ShipNavStatus
+	Location() string
 	Survey() []client.Survey
-	Undock()
-	Dock()
-	GetCooldown() client.Cooldown
-	ScanWaypoints() []client.ScannedWaypoint
-	Refresh()
+	Undock() error
+	Dock() error
+	ReadyAt() time.Time
+	FetchCooldown() error
+	ScanWaypoints() ([]client.ScannedWaypoint, error)
+	Refresh() error
 	SetCooldown(cooldown client.Cooldown)
+	SetClock(clock clock.Clock)
 	UpdateMarket() (client.Market, error)
-	HasLowFuel() bool
-	Refuel() client.Agent
+	HasLowFuel(threshold float64) bool
+	Refuel() (client.Agent, client.MarketTransaction, error)
 }
 
 type MinerShip interface {
@@ -66,407 +80,485 @@ const (
 	REFUEL         MinerState = "REFUEL"
 )
 
-func NewCooldown(expiration time.Time) client.Cooldown {
-	return client.Cooldown{
-		Expiration:       expiration,
-		RemainingSeconds: int(time.Until(expiration).Seconds()),
-	}
+// HasLowFuel reports whether the fuel is below the fraction threshold of the capacity.
+func (ship *Ship) HasLowFuel(threshold float64) bool {
+	return float64(ship.Fuel.Current) < (float64(ship.Fuel.Capacity) * threshold)
 }
 
-func (ship *Ship) HasLowFuel() bool {
-	return float64(ship.Fuel.Current) < (float64(ship.Fuel.Capacity) * 0.25)
+// refuelResponse is the part of the refuel response the generated client does
+// not know about yet.
+type refuelResponse struct {
+	Data struct {
+		Transaction client.MarketTransaction `json:"transaction"`
+	} `json:"data"`
 }
 
-func (ship *Ship) Refuel() client.Agent {
-	resp, err := Client.RefuelShipWithResponse(context.TODO(), ship.Symbol)
+// Refuel fills the tanks at the current market and publishes Refueled. It
+// returns the agent with the credits that are left and the purchase of the fuel.
+func (ship *Ship) Refuel() (client.Agent, client.MarketTransaction, error) {
+	resp, err := ship.Account.Client.RefuelShipWithResponse(context.TODO(), ship.Symbol)
 	if err != nil {
-		panic(err)
+		return client.Agent{}, client.MarketTransaction{}, err
+	}
+	if resp.StatusCode() != 200 {
+		return client.Agent{}, client.MarketTransaction{}, ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	body := refuelResponse{}
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		return client.Agent{}, client.MarketTransaction{}, err
 	}
 	data := resp.JSON200.Data
+	beforeFuel := ship.Fuel.Current
 	ship.Fuel = data.Fuel
-	return data.Agent
+	ship.Account.Events.Publish(Refueled{EventInfo: ship.event(), Role: string(ship.Registration.Role), Waypoint: ship.Nav.WaypointSymbol, Units: ship.Fuel.Current - beforeFuel, Cost: body.Data.Transaction.TotalPrice})
+	return data.Agent, body.Data.Transaction, nil
 }
 
 func (ship *Ship) UpdateMarket() (client.Market, error) {
-	resp, err := Client.GetMarketWithResponse(context.TODO(), ship.Nav.SystemSymbol, ship.Nav.WaypointSymbol)
+	resp, err := ship.Account.Client.GetMarketWithResponse(context.TODO(), ship.Nav.SystemSymbol, ship.Nav.WaypointSymbol)
 	if err != nil {
-		panic(err)
+		ship.Log().Error("Failed to get market", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "get market", Err: err})
+		return client.Market{}, err
 	}
 	if resp.StatusCode() != 200 {
 		if resp.StatusCode() == 404 {
 			return client.Market{}, errors.New("location does not have market")
 		}
-		panic(string(resp.Body))
+		err := ParseAPIError(resp.StatusCode(), resp.Body)
+		ship.Log().Error("Failed to get market", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "get market", Err: err})
+		return client.Market{}, err
 	}
+	ship.Account.Events.Publish(MarketUpdated{EventInfo: ship.event(), Market: resp.JSON200.Data})
 	return resp.JSON200.Data, nil
 }
 
-func (ship *Ship) Refresh() {
-	resp, err := Client.GetShipNavWithResponse(context.TODO(), ship.Symbol)
+// Refresh fetches the navigation of the ship and publishes ShipArrived once it
+// is no longer in transit.
+func (ship *Ship) Refresh() error {
+	resp, err := ship.Account.Client.GetShipNavWithResponse(context.TODO(), ship.Symbol)
 	if err != nil {
-		panic(err)
+		ship.Log().Error("Failed to refresh", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "refresh", Err: err})
+		return err
 	}
 	if resp.StatusCode() != 200 {
-		panic(string(resp.Body))
+		err := ParseAPIError(resp.StatusCode(), resp.Body)
+		ship.Log().Error("Failed to refresh", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "refresh", Err: err})
+		return err
 	}
+	arrived := ship.Nav.Status == client.INTRANSIT && resp.JSON200.Data.Status != client.INTRANSIT
 	ship.Nav = resp.JSON200.Data
+	if arrived {
+		ship.Account.Events.Publish(ShipArrived{EventInfo: ship.event(), Waypoint: ship.Nav.WaypointSymbol})
+	}
+	return nil
 }
 
 func (ship *Ship) IsFull() bool {
 	return ship.Cargo.Capacity == ship.Cargo.Units
 }
 
-func (ship *Ship) ScanWaypoints() []client.ScannedWaypoint {
-	resp, err := Client.CreateShipWaypointScanWithResponse(context.TODO(), ship.Symbol)
+func (ship *Ship) ScanWaypoints() ([]client.ScannedWaypoint, error) {
+	resp, err := ship.Account.Client.CreateShipWaypointScanWithResponse(context.TODO(), ship.Symbol)
 	if err != nil {
-		panic(err)
+		ship.Log().Error("Failed to scan waypoints", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "scan", Err: err})
+		return nil, err
 	}
 	if resp.StatusCode() != 201 {
-		panic(string(resp.Body))
+		err := ParseAPIError(resp.StatusCode(), resp.Body)
+		ship.Log().Error("Failed to scan waypoints", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "scan", Err: err})
+		ship.checkCooldown(err)
+		return nil, err
 	}
 	data := resp.JSON201.Data
 	ship.SetCooldown(data.Cooldown)
-	return data.Waypoints
+	return data.Waypoints, nil
+}
+
+// ReadyAt returns when the ship can act again: once its reactor cooled down,
+// it arrived and it is done waiting.
+func (ship *Ship) ReadyAt() time.Time {
+	ready := ship.Wait
+	if ship.Cooldown.Expiration.After(ready) {
+		ready = ship.Cooldown.Expiration
+	}
+	if ship.Nav.Status == client.INTRANSIT && ship.Nav.Route.Arrival.After(ready) {
+		ready = ship.Nav.Route.Arrival
+	}
+	return ready
+}
+
+// FetchCooldown asks the API for the reactor cooldown, to learn about cooldowns
+// that did not start with a response we have seen, like before a restart.
+func (ship *Ship) FetchCooldown() error {
+	resp, err := ship.Account.Client.GetShipCooldownWithResponse(context.TODO(), ship.Symbol)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode() {
+	case 200:
+		ship.SetCooldown(resp.JSON200.Data)
+	case 204:
+		// no cooldown
+		ship.Cooldown = client.Cooldown{}
+	default:
+		return ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	return nil
 }
 
-func (ship *Ship) GetCooldown() client.Cooldown {
-	return ship.Cooldown
+// checkCooldown fetches the reactor cooldown after an error that says the ship
+// is still cooling down, so it waits for it instead of failing again.
+func (ship *Ship) checkCooldown(err error) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !apiErr.IsCooldown() {
+		return
+	}
+	if err := ship.FetchCooldown(); err != nil {
+		ship.Log().Error("Failed to fetch cooldown", "error", err)
+		return
+	}
+	ship.Log().Warn("Ship was still cooling down", "until", ship.Cooldown.Expiration)
 }
 
+// SetCooldown starts a reactor cooldown after an action.
 func (ship *Ship) SetCooldown(cooldown client.Cooldown) {
-	log.Printf("Ship %s is in cooldown for %d seconds", ship.Symbol, cooldown.RemainingSeconds)
+	ship.Log().Debug("Cooldown", "seconds", cooldown.RemainingSeconds)
 	ship.Cooldown = cooldown
+	ship.Account.Events.Publish(CooldownStarted{EventInfo: ship.event(), Cooldown: cooldown})
+}
+
+func (ship *Ship) SetClock(clock clock.Clock) {
+	ship.Clock = clock
 }
 
 func (ship *Ship) Status() client.ShipNavStatus {
 	return ship.Nav.Status
 }
 
-func (ship *Ship) Undock() {
-	resp, err := Client.OrbitShipWithResponse(context.TODO(), ship.Symbol)
+func (ship *Ship) Location() string {
+	return ship.Nav.WaypointSymbol
+}
+
+func (ship *Ship) Undock() error {
+	resp, err := ship.Account.Client.OrbitShipWithResponse(context.TODO(), ship.Symbol)
 	if err != nil {
-		panic(err)
+		ship.Log().Error("Failed to orbit", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "orbit", Err: err})
+		return err
 	}
 	if resp.StatusCode() != 200 {
-		panic(string(resp.Body))
+		err := ParseAPIError(resp.StatusCode(), resp.Body)
+		ship.Log().Error("Failed to orbit", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "orbit", Err: err})
+		return err
 	}
 	ship.Nav = resp.JSON200.Data.Nav
+	return nil
 }
 
-func (ship *Ship) Dock() {
-	resp, err := Client.DockShipWithResponse(context.TODO(), ship.Symbol)
+func (ship *Ship) Dock() error {
+	resp, err := ship.Account.Client.DockShipWithResponse(context.TODO(), ship.Symbol)
 	if err != nil {
-		panic(err)
+		ship.Log().Error("Failed to dock", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "dock", Err: err})
+		return err
 	}
 	if resp.StatusCode() != 200 {
-		panic(string(resp.Body))
+		err := ParseAPIError(resp.StatusCode(), resp.Body)
+		ship.Log().Error("Failed to dock", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "dock", Err: err})
+		return err
 	}
 	ship.Nav = resp.JSON200.Data.Nav
+	return nil
 }
 
-func (ship *Ship) GoTo(waypoint *client.Waypoint) {
-	ship.GoToSymbol(waypoint.Symbol)
+func (ship *Ship) GoTo(waypoint *client.Waypoint) error {
+	return ship.GoToSymbol(waypoint.Symbol)
 }
-func (ship *Ship) GoToSymbol(dest string) {
-	resp, err := Client.NavigateShipWithResponse(context.TODO(), ship.Symbol, client.NavigateShipJSONRequestBody{
+func (ship *Ship) GoToSymbol(dest string) error {
+	resp, err := ship.Account.Client.NavigateShipWithResponse(context.TODO(), ship.Symbol, client.NavigateShipJSONRequestBody{
 		WaypointSymbol: dest,
 	})
 	if err != nil {
-		panic(err)
+		ship.Log().Error("Failed to navigate", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "navigate", Err: err})
+		return err
 	}
 	if resp.StatusCode() != 200 {
-		panic(string(resp.Body))
+		err := ParseAPIError(resp.StatusCode(), resp.Body)
+		ship.Log().Error("Failed to navigate", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "navigate", Err: err})
+		return err
 	}
 	data := resp.JSON200.Data
 	ship.Nav = data.Nav
 	ship.Fuel = data.Fuel
-	ship.SetCooldown(NewCooldown(ship.Nav.Route.Arrival))
+	return nil
 }
 
 func (ship *Ship) Survey() []client.Survey {
-	resp, err := Client.CreateSurveyWithResponse(context.TODO(), ship.Symbol)
-	if err != nil || resp.StatusCode() != 201 {
-
-		fmt.Println(err)
-		fmt.Printf("%s\n", resp.Body)
+	resp, err := ship.Account.Client.CreateSurveyWithResponse(context.TODO(), ship.Symbol)
+	if err != nil {
+		ship.Log().Error("Failed to survey", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "survey", Err: err})
+		return nil
+	}
+	if resp.StatusCode() != 201 {
+		err := ParseAPIError(resp.StatusCode(), resp.Body)
+		ship.Log().Error("Failed to survey", "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "survey", Err: err})
+		ship.checkCooldown(err)
+		return nil
 	}
 	data := resp.JSON201.Data
 	ship.SetCooldown(data.Cooldown)
+	ship.Account.Events.Publish(SurveyCreated{EventInfo: ship.event(), Waypoint: ship.Nav.WaypointSymbol, Surveys: data.Surveys})
 	return data.Surveys
 }
 
-func (ship *Miner) Sell(good client.TradeSymbol) (client.Agent, client.MarketTransaction) {
+// Sell sells all units of the good in the cargo hold.
+func (ship *Miner) Sell(good client.TradeSymbol) (client.Agent, client.MarketTransaction, error) {
+	units := ship.CargoUnits(string(good))
+	if units == 0 {
+		return client.Agent{}, client.MarketTransaction{}, fmt.Errorf("no %s to sell", good)
+	}
+	agent, trans, err := ship.SellCargo(string(good), units)
+	if err != nil {
+		ship.Log().Error("Failed to sell", "good", good, "error", err)
+		ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "sell", Err: err})
+		return client.Agent{}, client.MarketTransaction{}, err
+	}
+	return agent, trans, nil
+}
+
+// SellCargo sells units of the good at the market the ship is docked at.
+func (ship *Ship) SellCargo(good string, units int) (client.Agent, client.MarketTransaction, error) {
+	resp, err := ship.Account.Client.SellCargoWithResponse(context.TODO(), ship.Symbol, client.SellCargoJSONRequestBody{
+		Symbol: good,
+		Units:  units,
+	})
+	if err != nil {
+		return client.Agent{}, client.MarketTransaction{}, err
+	}
+	if resp.StatusCode() != 201 {
+		return client.Agent{}, client.MarketTransaction{}, ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	data := resp.JSON201.Data
+	ship.Cargo = data.Cargo
+	ship.Account.Events.Publish(Sold{EventInfo: ship.event(), Role: string(ship.Registration.Role), Transaction: data.Transaction})
+	return data.Agent, data.Transaction, nil
+}
+
+func (ship *Ship) Purchase(good client.TradeSymbol, units int) (client.Agent, client.MarketTransaction, error) {
+	resp, err := ship.Account.Client.PurchaseCargoWithResponse(context.TODO(), ship.Symbol, client.PurchaseCargoJSONRequestBody{
+		Symbol: string(good),
+		Units:  units,
+	})
+	if err != nil {
+		return client.Agent{}, client.MarketTransaction{}, err
+	}
+	if resp.StatusCode() != 201 {
+		return client.Agent{}, client.MarketTransaction{}, ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	data := resp.JSON201.Data
+	ship.Cargo = data.Cargo
+	ship.Account.Events.Publish(Bought{EventInfo: ship.event(), Role: string(ship.Registration.Role), Transaction: data.Transaction})
+	return data.Agent, data.Transaction, nil
+}
+
+// CargoUnits returns how many units of the good are in the cargo hold.
+func (ship *Ship) CargoUnits(good string) int {
 	for _, c := range ship.Cargo.Inventory {
-		if c.Symbol == string(good) {
-			resp, err := Client.SellCargoWithResponse(context.TODO(), ship.Symbol, client.SellCargoJSONRequestBody{
-				Symbol: c.Symbol,
-				Units:  c.Units,
-			})
-			if err != nil {
-				panic(err)
-			}
-			if resp.StatusCode() != 201 {
-				panic(string(resp.Body))
-			}
-			data := resp.JSON201.Data
-			ship.Cargo = data.Cargo
-			return data.Agent, data.Transaction
+		if c.Symbol == good {
+			return c.Units
+		}
+	}
+	return 0
+}
+
+// MoveTo takes a single step towards being docked at the destination: it waits for
+// an ongoing transit, undocks, navigates or docks as needed. It returns true once
+// the ship is docked at the destination, and the error of a step that failed.
+func (ship *Ship) MoveTo(dest string) (bool, error) {
+	if ship.Status() == client.INTRANSIT {
+		if err := ship.Refresh(); err != nil {
+			return false, err
+		}
+		if ship.Status() == client.INTRANSIT {
+			return false, nil
 		}
 	}
-	// We only call this with a goods check so this does not happen
-	return client.Agent{}, client.MarketTransaction{}
+	if ship.Nav.WaypointSymbol == dest {
+		if ship.Status() != client.DOCKED {
+			err := ship.Dock()
+			return err == nil, err
+		}
+		return true, nil
+	}
+	if ship.Status() == client.DOCKED {
+		if err := ship.Undock(); err != nil {
+			return false, err
+		}
+	}
+	return false, ship.GoToSymbol(dest)
+}
+
+func (ship *Ship) DeliverContract(contractId string, good string, units int) (client.Contract, error) {
+	resp, err := ship.Account.Client.DeliverContractWithResponse(context.TODO(), contractId, client.DeliverContractJSONRequestBody{
+		ShipSymbol:  ship.Symbol,
+		TradeSymbol: good,
+		Units:       units,
+	})
+	if err != nil {
+		return client.Contract{}, err
+	}
+	if resp.StatusCode() != 200 {
+		return client.Contract{}, ParseAPIError(resp.StatusCode(), resp.Body)
+	}
+	data := resp.JSON200.Data
+	ship.Cargo = data.Cargo
+	ship.Account.Events.Publish(ContractUpdated{EventInfo: ship.event(), Change: CONTRACT_DELIVERED, Contract: data.Contract})
+	return data.Contract, nil
 }
 
-func (ship *Miner) Jettison(good client.TradeSymbol) {
+func (ship *Miner) Jettison(good client.TradeSymbol) error {
 	for _, c := range ship.Cargo.Inventory {
 		if c.Symbol == string(good) {
-			resp, err := Client.JettisonWithResponse(context.TODO(), ship.Symbol, client.JettisonJSONRequestBody{
+			resp, err := ship.Account.Client.JettisonWithResponse(context.TODO(), ship.Symbol, client.JettisonJSONRequestBody{
 				Symbol: c.Symbol,
 				Units:  c.Units,
 			})
 			if err != nil {
-				panic(err)
+				ship.Log().Error("Failed to jettison", "error", err)
+				ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "jettison", Err: err})
+				return err
 			}
 			if resp.StatusCode() != 200 {
-				panic(string(resp.Body))
+				err := ParseAPIError(resp.StatusCode(), resp.Body)
+				ship.Log().Error("Failed to jettison", "error", err)
+				ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "jettison", Err: err})
+				return err
 			}
 			data := resp.JSON200.Data
 			ship.Cargo = data.Cargo
 		}
 	}
+	return nil
 }
 
+// Deliver hands over all cargo the contract still needs at the current waypoint.
 func (ship *Miner) Deliver() (client.Contract, error) {
-	symbol := (*ship.Contract.Terms.Deliver)[0].TradeSymbol
-	units := 0
-	for _, c := range ship.Cargo.Inventory {
-		if c.Symbol == symbol {
-			units = c.Units
+	delivered := false
+	for _, deliver := range RemainingDeliveries(ship.Contract) {
+		if deliver.DestinationSymbol != ship.Nav.WaypointSymbol {
+			continue
 		}
-	}
-	if units != 0 {
-		resp, err := Client.DeliverContractWithResponse(context.TODO(), ship.Contract.Id, client.DeliverContractJSONRequestBody{
-			ShipSymbol:  ship.Symbol,
-			TradeSymbol: symbol,
-			Units:       units,
-		})
-		if err != nil {
-			panic(err)
+		units := 0
+		for _, c := range ship.Cargo.Inventory {
+			if c.Symbol == deliver.TradeSymbol {
+				units = c.Units
+			}
+		}
+		if remaining := deliver.UnitsRequired - deliver.UnitsFulfilled; units > remaining {
+			units = remaining
 		}
-		if resp.StatusCode() != 200 {
-			panic(string(resp.Body))
+		if units == 0 {
+			continue
 		}
-		data := resp.JSON200.Data
-		ship.Contract = data.Contract
-		ship.Cargo = data.Cargo
-		return data.Contract, nil
+		contract, err := ship.DeliverContract(ship.Contract.Id, deliver.TradeSymbol, units)
+		if err != nil {
+			ship.Log().Error("Failed to deliver", "good", deliver.TradeSymbol, "error", err)
+			ship.Account.Events.Publish(ShipError{EventInfo: ship.event(), Action: "deliver", Err: err})
+			return client.Contract{}, err
+		}
+		ship.Contract = contract
+		delivered = true
+	}
+	if !delivered {
+		return client.Contract{}, errors.New("nothing to deliver")
 	}
-	return client.Contract{}, errors.New("nothing to deliver")
+	return ship.Contract, nil
 }
 
-func (ship *Miner) Extract() *client.Extraction {
-	resp, err := Client.ExtractResourcesWithResponse(context.TODO(), ship.Symbol, client.ExtractResourcesJSONRequestBody{
-		Survey: &ship.Target,
+func (ship *Miner) Extract() (*client.Extraction, error) {
+	resp, err := ship.Account.Client.ExtractResourcesWithResponse(context.TODO(), ship.Symbol, client.ExtractResourcesJSONRequestBody{
+		Survey: ship.Target,
 	})
 	if err != nil {
-		log.Printf("Failed to extract: %v", err)
-		return nil
+		return nil, err
+	}
+	if resp.StatusCode() != 201 {
+		err := ParseAPIError(resp.StatusCode(), resp.Body)
+		ship.checkCooldown(err)
+		return nil, err
 	}
 	data := resp.JSON201.Data
 	ship.SetCooldown(data.Cooldown)
 	ship.Cargo = data.Cargo
-	return &data.Extraction
+	ship.Account.Events.Publish(Extracted{EventInfo: ship.event(), Waypoint: ship.Nav.WaypointSymbol, Yield: data.Extraction.Yield})
+	if ship.IsFull() {
+		ship.Account.Events.Publish(CargoFull{EventInfo: ship.event(), Waypoint: ship.Nav.WaypointSymbol, Units: ship.Cargo.Units})
+	}
+	return &data.Extraction, nil
 }
 
 func (ship *Miner) HasContractGood() bool {
-	for _, deliver := range *ship.Contract.Terms.Deliver {
+	return ship.ContractDestination() != ""
+}
+
+// ContractDestination returns where the first contract good in the cargo hold needs
+// to be delivered, or an empty string if we carry nothing the contract still needs.
+func (ship *Miner) ContractDestination() string {
+	for _, deliver := range RemainingDeliveries(ship.Contract) {
 		for _, ci := range ship.Cargo.Inventory {
 			if deliver.TradeSymbol == ci.Symbol {
-				return true
+				return deliver.DestinationSymbol
 			}
 		}
 	}
-	return false
+	return ""
 }
 
 func (ship *Miner) CanSellHere(market client.Market) bool {
+	if ship.Account.Strategy.IsBlacklisted(market.Symbol) {
+		return false
+	}
 	canSell := market.GetImportAndExchangeGoods()
 	cargo := ship.Cargo.GetCargoGoodsExceptAntimatter()
 	toSell := intersect.Hash(canSell, cargo)
 	return len(toSell) > 0
 }
 
+// InitState starts the state machine over in the state derived from the ship.
 func (ship *Miner) InitState() {
+	ship.State = ship.DeriveState()
+}
+
+// DeriveState returns the state that matches the ship's nav.
+func (ship *Miner) DeriveState() MinerState {
 	switch ship.Status() {
 	case client.DOCKED:
-		ship.State = DOCKED
+		return DOCKED
 	case client.INORBIT:
 		switch ship.Nav.Route.Destination.Type {
 		case client.WaypointTypeASTEROIDFIELD:
-			ship.State = ORBIT_ASTEROID
+			return ORBIT_ASTEROID
 		case client.WaypointTypeORBITALSTATION:
-			ship.State = ORBIT_STATION
+			return ORBIT_STATION
 		case client.WaypointTypePLANET:
-			ship.State = ORBIT_STATION
+			return ORBIT_STATION
 		default:
-			ship.State = ORBIT_STATION
+			return ORBIT_STATION
 		}
 	case client.INTRANSIT:
-		ship.State = IN_TRANSIT
-	}
-
-}
-
-func (ship *Miner) Run(gameState *State) {
-	if ship.State == "" {
-		ship.InitState()
-	}
-	log.Printf("Miner %s in state %s\n", ship.Symbol, ship.State)
-	switch ship.State {
-	case REFUEL:
-		beforeFuel := ship.Fuel.Current
-		beforeCredits := gameState.Agent.Credits
-		agent := ship.Refuel()
-		gameState.Agent = agent
-		afterFuel := ship.Fuel.Current
-		afterCredits := gameState.Agent.Credits
-		log.Printf("Bought %d fuel for %d credits, %d credits remaining", afterFuel-beforeFuel, beforeCredits-afterCredits, afterCredits)
-		ship.State = DOCKED
-	case DOCKED:
-		if ship.IsFull() {
-			contract, err := ship.Deliver()
-			if err == nil {
-				good := (*contract.Terms.Deliver)[0]
-				log.Printf("Delivered %s, %d/%d fulfilled", good.TradeSymbol, good.UnitsFulfilled, good.UnitsRequired)
-				gameState.Contracts[0] = contract
-			}
-		}
-		ship.State = SELL_REMAINING
-	case UPDATE_MARKET:
-		market, err := ship.UpdateMarket()
-		if err == nil {
-			gameState.UpdateMarket(market)
-		}
-		ship.State = ORBIT_STATION
-	case SELL_REMAINING:
-		market := gameState.Markets[ship.Nav.WaypointSymbol]
-		canSell := market.GetImportAndExchangeGoods()
-		cargo := ship.Cargo.GetCargoGoodsExceptAntimatter()
-		toSell := intersect.Hash(canSell, cargo)
-		if len(toSell) > 0 {
-			good, _ := toSell[0].(client.TradeSymbol)
-			agent, trans := ship.Sell(good)
-			gameState.Agent = agent
-			log.Printf("Sold %d %s for %d credits", trans.Units, trans.TradeSymbol, trans.TotalPrice)
-			log.Printf("Account now holds %d credits", agent.Credits)
-		} else {
-			ship.Undock()
-			ship.State = ORBIT_STATION
-		}
-		// }
-	case ORBIT_STATION:
-		market, ok := gameState.Markets[ship.Nav.WaypointSymbol]
-		if !ok && gameState.Waypoints[ship.Nav.WaypointSymbol].HasMarket() {
-			ship.State = UPDATE_MARKET
-		} else if (ship.HasContractGood() && ship.Nav.WaypointSymbol == (*ship.Contract.Terms.Deliver)[0].DestinationSymbol) || ship.CanSellHere(market) {
-			ship.Dock()
-			ship.State = DOCKED
-		} else if ship.HasLowFuel() {
-			ship.Dock()
-			ship.State = REFUEL
-		} else if len(ship.Cargo.Inventory) > 1 {
-			ship.State = FIND_SELL
-		} else {
-			if gameState.GetAsteroid(ship.Nav.SystemSymbol) == nil {
-				waypoints := ship.ScanWaypoints()
-				gameState.AddWaypoints(ship.Nav.SystemSymbol, waypoints)
-			}
-			ship.State = START_TRAVEL
-		}
-	case START_TRAVEL:
-		log.Printf("Ship %s leaving station with %d free cargo space", ship.Symbol, ship.Cargo.Capacity-ship.Cargo.Units)
-		wp := gameState.GetAsteroid(ship.Nav.SystemSymbol)
-		if ship.Nav.WaypointSymbol != wp.Symbol {
-			ship.GoTo((*client.Waypoint)(wp))
-		}
-		ship.State = IN_TRANSIT
-	case FIND_SELL:
-		// sellCargo := ship.Cargo.GetCargoGoodsExceptAntimatter()
-		waypoints, haveWaypoints := gameState.WaypointsBySystem[ship.Nav.SystemSymbol]
-		if !haveWaypoints {
-			waypoints := ship.ScanWaypoints()
-			gameState.AddWaypoints(ship.Nav.SystemSymbol, waypoints)
-		} else {
-			found := false
-			for _, wp := range waypoints {
-				if wp.HasMarket() {
-					market, haveMarket := gameState.Markets[wp.Symbol]
-					if ship.Nav.WaypointSymbol != wp.Symbol && (!haveMarket || (haveMarket && ship.CanSellHere(market))) {
-						found = true
-						ship.GoTo((*client.Waypoint)(&wp))
-						ship.State = IN_TRANSIT
-						break
-					} else if haveMarket && ship.CanSellHere(market) {
-						found = true
-						ship.Dock()
-						ship.State = DOCKED
-						break
-					}
-				}
-
-			}
-			if !found {
-				ship.State = JETTISON
-			}
-		}
-	case JETTISON:
-		log.Println("We need to jettison the remaining cargo")
-		cargo := ship.Cargo.GetCargoGoodsExceptAntimatter()
-		if len(cargo) > 0 {
-			ship.Jettison(cargo[0])
-			log.Printf("Jettisoned all %s", cargo[0])
-		} else {
-			ship.State = ORBIT_STATION
-		}
-	case ORBIT_ASTEROID:
-		if ship.IsFull() && ship.HasContractGood() {
-			dest := (*ship.Contract.Terms.Deliver)[0].DestinationSymbol
-			ship.GoToSymbol(dest)
-			ship.State = IN_TRANSIT
-		} else if ship.IsFull() {
-			ship.State = FIND_SELL
-		} else {
-			survey := gameState.GetOreSurvey(ship.Nav.WaypointSymbol, (*ship.Contract.Terms.Deliver)[0].TradeSymbol)
-			if survey == nil {
-				ship.State = SURVEY
-			} else {
-				log.Printf("Targetting survey %s, which is valid for %d", survey.Symbol, int(time.Until(survey.Expiration).Seconds()))
-				ship.Target = *survey
-				ship.State = EXTRACT
-			}
-		}
-	case SURVEY:
-		surveys := ship.Survey()
-		gameState.AddSurveys(ship.Nav.WaypointSymbol, surveys)
-		for _, survey := range surveys {
-			for _, dep := range survey.Deposits {
-				fmt.Println(dep.Symbol, survey.Symbol)
-			}
-		}
-		ship.State = ORBIT_ASTEROID
-	case EXTRACT:
-		if ship.Target.Expiration.After(time.Now().Add(time.Second)) {
-			e := ship.Extract()
-			log.Printf("Miner %s extracted %d %s\n", ship.Symbol, e.Yield.Units, e.Yield.Symbol)
-		} else {
-			ship.State = ORBIT_ASTEROID
-		}
-		if ship.Cargo.Capacity == ship.Cargo.Units {
-			ship.State = ORBIT_ASTEROID
-		}
-	case IN_TRANSIT:
-		// do nothing?
-		ship.Refresh()
-		ship.InitState()
+		return IN_TRANSIT
 	}
+	return ""
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+	"github.com/Dutchy-/spacetrader-go/main/clock"
+)
+
+func TestReadyAt(t *testing.T) {
+	start := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		wait     time.Duration
+		cooldown time.Duration
+		transit  time.Duration
+		ready    time.Duration
+	}{
+		{name: "idle", ready: 0},
+		{name: "waiting", wait: 30 * time.Second, ready: 30 * time.Second},
+		{name: "cooling down", cooldown: 70 * time.Second, ready: 70 * time.Second},
+		{name: "in transit", transit: 2 * time.Minute, ready: 2 * time.Minute},
+		{name: "latest wins", wait: 30 * time.Second, cooldown: 70 * time.Second, transit: time.Minute, ready: 70 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := clock.NewFake(start)
+			ship := Ship{Clock: fake}
+			if test.wait > 0 {
+				ship.Wait = fake.Now().Add(test.wait)
+			}
+			if test.cooldown > 0 {
+				ship.Cooldown.Expiration = fake.Now().Add(test.cooldown)
+			}
+			if test.transit > 0 {
+				ship.Nav.Status = client.INTRANSIT
+				ship.Nav.Route.Arrival = fake.Now().Add(test.transit)
+			}
+			want := time.Time{}
+			if test.ready > 0 {
+				want = start.Add(test.ready)
+			}
+			if ready := ship.ReadyAt(); !ready.Equal(want) {
+				t.Errorf("ReadyAt() = %v, want %v", ready, want)
+			}
+			fake.Advance(test.ready - time.Second)
+			if test.ready > 0 && !ship.ReadyAt().After(fake.Now()) {
+				t.Errorf("ready a second early")
+			}
+			fake.Advance(time.Second)
+			if ship.ReadyAt().After(fake.Now()) {
+				t.Errorf("not ready after %v", test.ready)
+			}
+		})
+	}
+}
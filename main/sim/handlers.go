@@ -0,0 +1,464 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+func (s *Server) requireStatus(ship *client.Ship, status client.ShipNavStatus) *httpError {
+	if ship.Nav.Status == status {
+		return nil
+	}
+	switch status {
+	case client.DOCKED:
+		return fail(http.StatusBadRequest, ErrCodeNotDocked, "Ship %s is not docked at %s.", ship.Symbol, ship.Nav.WaypointSymbol)
+	case client.INORBIT:
+		if ship.Nav.Status == client.INTRANSIT {
+			return fail(http.StatusBadRequest, ErrCodeInTransit, "Ship %s is currently in-transit to %s.", ship.Symbol, ship.Nav.WaypointSymbol)
+		}
+		return fail(http.StatusBadRequest, ErrCodeNotInOrbit, "Ship %s is not in orbit at %s.", ship.Symbol, ship.Nav.WaypointSymbol)
+	}
+	return fail(http.StatusBadRequest, ErrCodeBadRequest, "Ship %s has status %s.", ship.Symbol, ship.Nav.Status)
+}
+
+func (s *Server) requireNoCooldown(ship *client.Ship) *httpError {
+	if cooldown, ok := s.cooldown(ship); ok {
+		err := fail(http.StatusConflict, ErrCodeCooldown, "Ship action is still on cooldown for %d second(s).", cooldown.RemainingSeconds)
+		err.Data = map[string]interface{}{"cooldown": cooldown}
+		return err
+	}
+	return nil
+}
+
+func hasMount(ship *client.Ship, symbols ...client.ShipMountSymbol) bool {
+	for _, mount := range ship.Mounts {
+		for _, symbol := range symbols {
+			if mount.Symbol == symbol {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Server) register(r *http.Request) (*response, *httpError) {
+	body := client.RegisterJSONRequestBody{}
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	if body.Symbol == "" {
+		return nil, fail(http.StatusBadRequest, ErrCodeBadRequest, "Agent symbol is required.")
+	}
+	s.player.agent.Symbol = body.Symbol
+	token := s.opts.Token
+	if token == "" {
+		token = "sim-token"
+	}
+	return data(201, map[string]interface{}{
+		"agent":    s.player.agent,
+		"contract": s.player.contracts[0],
+		"faction":  client.Faction{Symbol: FACTION, Name: FACTION, Headquarters: HEADQUARTERS, Traits: []client.FactionTrait{}},
+		"ship":     s.player.ships[0],
+		"token":    token,
+	}), nil
+}
+
+func (s *Server) dock(ship *client.Ship) (*response, *httpError) {
+	if ship.Nav.Status == client.INTRANSIT {
+		return nil, s.requireStatus(ship, client.INORBIT)
+	}
+	ship.Nav.Status = client.DOCKED
+	return data(200, map[string]interface{}{"nav": ship.Nav}), nil
+}
+
+func (s *Server) orbit(ship *client.Ship) (*response, *httpError) {
+	if ship.Nav.Status == client.INTRANSIT {
+		return nil, s.requireStatus(ship, client.INORBIT)
+	}
+	ship.Nav.Status = client.INORBIT
+	return data(200, map[string]interface{}{"nav": ship.Nav}), nil
+}
+
+func (s *Server) navigate(r *http.Request, ship *client.Ship) (*response, *httpError) {
+	body := client.NavigateShipJSONRequestBody{}
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	if err := s.requireStatus(ship, client.INORBIT); err != nil {
+		return nil, err
+	}
+	if s.waypoint(body.WaypointSymbol) == nil {
+		return nil, fail(http.StatusNotFound, 404, "Waypoint %s not found", body.WaypointSymbol)
+	}
+	if body.WaypointSymbol == ship.Nav.WaypointSymbol {
+		return nil, fail(http.StatusBadRequest, ErrCodeBadRequest, "Ship %s is already at %s.", ship.Symbol, body.WaypointSymbol)
+	}
+	departure := s.routeWaypoint(ship.Nav.WaypointSymbol)
+	destination := s.routeWaypoint(body.WaypointSymbol)
+	dist := math.Max(1, math.Round(distance(departure, destination)))
+	fuel := int(dist)
+	if ship.Fuel.Capacity > 0 {
+		if fuel > ship.Fuel.Current {
+			return nil, fail(http.StatusBadRequest, ErrCodeInsufficientFuel, "Ship %s has %d fuel but needs %d.", ship.Symbol, ship.Fuel.Current, fuel)
+		}
+		ship.Fuel.Current -= fuel
+	}
+	speed := float64(ship.Engine.Speed)
+	if speed <= 0 {
+		speed = 30
+	}
+	travel := time.Duration(dist * 30 / speed * float64(s.opts.TravelTime))
+	now := s.opts.Clock.Now()
+	ship.Nav.Status = client.INTRANSIT
+	ship.Nav.WaypointSymbol = body.WaypointSymbol
+	ship.Nav.Route = client.ShipNavRoute{Departure: departure, Destination: destination, DepartureTime: now, Arrival: now.Add(travel)}
+	return data(200, map[string]interface{}{"nav": ship.Nav, "fuel": ship.Fuel}), nil
+}
+
+func (s *Server) extract(r *http.Request, ship *client.Ship) (*response, *httpError) {
+	body := client.ExtractResourcesJSONRequestBody{}
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	if err := s.requireStatus(ship, client.INORBIT); err != nil {
+		return nil, err
+	}
+	if err := s.requireNoCooldown(ship); err != nil {
+		return nil, err
+	}
+	deposits, ok := s.deposits[ship.Nav.WaypointSymbol]
+	if !ok {
+		return nil, fail(http.StatusBadRequest, ErrCodeBadRequest, "Waypoint %s is not an asteroid field.", ship.Nav.WaypointSymbol)
+	}
+	strength := ship.MiningStrength()
+	if strength == 0 {
+		return nil, fail(http.StatusBadRequest, ErrCodeBadRequest, "Ship %s does not have a mining laser.", ship.Symbol)
+	}
+	free := ship.Cargo.Capacity - ship.Cargo.Units
+	if free <= 0 {
+		return nil, fail(http.StatusBadRequest, ErrCodeCargoFull, "Ship %s cargo hold is full.", ship.Symbol)
+	}
+	if body.Survey != nil {
+		sv, ok := s.surveys[body.Survey.Signature]
+		switch {
+		case !ok || sv.Symbol != ship.Nav.WaypointSymbol:
+			return nil, fail(http.StatusBadRequest, ErrCodeSurveyVerification, "Survey %s failed validation.", body.Survey.Signature)
+		case !sv.Expiration.After(s.opts.Clock.Now()):
+			delete(s.surveys, sv.Signature)
+			return nil, fail(http.StatusBadRequest, ErrCodeSurveyExpired, "Survey %s has expired.", sv.Signature)
+		case sv.Remaining <= 0:
+			return nil, fail(http.StatusBadRequest, ErrCodeSurveyExhausted, "Survey %s has been exhausted.", sv.Signature)
+		}
+		sv.Remaining--
+		deposits = []string{}
+		for _, dep := range sv.Deposits {
+			deposits = append(deposits, dep.Symbol)
+		}
+	}
+	good := deposits[s.rng.Intn(len(deposits))]
+	units := strength/2 + s.rng.Intn(strength/2+1)
+	if units > free {
+		units = free
+	}
+	s.addCargo(ship, good, units)
+	cooldown := s.startCooldown(ship, s.opts.ExtractCooldown)
+	return data(201, map[string]interface{}{
+		"cooldown":   cooldown,
+		"cargo":      ship.Cargo,
+		"extraction": client.Extraction{ShipSymbol: ship.Symbol, Yield: client.ExtractionYield{Symbol: good, Units: units}},
+	}), nil
+}
+
+func (s *Server) survey(ship *client.Ship) (*response, *httpError) {
+	if err := s.requireStatus(ship, client.INORBIT); err != nil {
+		return nil, err
+	}
+	if err := s.requireNoCooldown(ship); err != nil {
+		return nil, err
+	}
+	if !hasMount(ship, client.MOUNTSURVEYORI, client.MOUNTSURVEYORII, client.MOUNTSURVEYORIII) {
+		return nil, fail(http.StatusBadRequest, ErrCodeBadRequest, "Ship %s does not have a surveyor.", ship.Symbol)
+	}
+	deposits, ok := s.deposits[ship.Nav.WaypointSymbol]
+	if !ok {
+		return nil, fail(http.StatusBadRequest, ErrCodeBadRequest, "Waypoint %s can not be surveyed.", ship.Nav.WaypointSymbol)
+	}
+	sizes := []client.SurveySize{client.SurveySizeSMALL, client.SurveySizeMODERATE, client.SurveySizeLARGE}
+	extractions := map[client.SurveySize]int{client.SurveySizeSMALL: 10, client.SurveySizeMODERATE: 25, client.SurveySizeLARGE: 50}
+	surveys := []client.Survey{}
+	for i := 0; i < 1+s.rng.Intn(3); i++ {
+		sv := &survey{Survey: client.Survey{
+			Symbol:     ship.Nav.WaypointSymbol,
+			Signature:  fmt.Sprintf("%s-%04X", ship.Nav.WaypointSymbol, s.rng.Intn(0x10000)),
+			Size:       sizes[s.rng.Intn(len(sizes))],
+			Expiration: s.opts.Clock.Now().Add(s.opts.SurveyLifetime),
+		}}
+		for j := 0; j < 3+s.rng.Intn(4); j++ {
+			sv.Deposits = append(sv.Deposits, client.SurveyDeposit{Symbol: deposits[s.rng.Intn(len(deposits))]})
+		}
+		sv.Remaining = extractions[sv.Size]
+		s.surveys[sv.Signature] = sv
+		surveys = append(surveys, sv.Survey)
+	}
+	cooldown := s.startCooldown(ship, s.opts.SurveyCooldown)
+	return data(201, map[string]interface{}{"cooldown": cooldown, "surveys": surveys}), nil
+}
+
+func (s *Server) scanWaypoints(ship *client.Ship) (*response, *httpError) {
+	if ship.Nav.Status == client.INTRANSIT {
+		return nil, s.requireStatus(ship, client.INORBIT)
+	}
+	if err := s.requireNoCooldown(ship); err != nil {
+		return nil, err
+	}
+	waypoints := []client.ScannedWaypoint{}
+	for _, wp := range s.waypoints {
+		waypoints = append(waypoints, client.ScannedWaypoint(wp))
+	}
+	cooldown := s.startCooldown(ship, s.opts.ScanCooldown)
+	return data(201, map[string]interface{}{"cooldown": cooldown, "waypoints": waypoints}), nil
+}
+
+// tradeGood returns the good as traded at the market the ship is docked at.
+func (s *Server) tradeGood(ship *client.Ship, good string) (*client.MarketTradeGood, *httpError) {
+	if err := s.requireStatus(ship, client.DOCKED); err != nil {
+		return nil, err
+	}
+	market, ok := s.markets[ship.Nav.WaypointSymbol]
+	if !ok {
+		return nil, fail(http.StatusNotFound, 404, "Waypoint %s does not have a market.", ship.Nav.WaypointSymbol)
+	}
+	for i, tg := range *market.TradeGoods {
+		if tg.Symbol == good {
+			return &(*market.TradeGoods)[i], nil
+		}
+	}
+	return nil, fail(http.StatusBadRequest, ErrCodeMarketTrade, "Market %s does not trade %s.", market.Symbol, good)
+}
+
+func (s *Server) transaction(ship *client.Ship, good string, units int, price int, kind client.MarketTransactionType) client.MarketTransaction {
+	transaction := client.MarketTransaction{
+		ShipSymbol:     ship.Symbol,
+		WaypointSymbol: ship.Nav.WaypointSymbol,
+		TradeSymbol:    good,
+		Type:           kind,
+		Units:          units,
+		PricePerUnit:   price,
+		TotalPrice:     units * price,
+		Timestamp:      s.opts.Clock.Now(),
+	}
+	market := s.markets[ship.Nav.WaypointSymbol]
+	*market.Transactions = append(*market.Transactions, transaction)
+	return transaction
+}
+
+func (s *Server) sell(r *http.Request, ship *client.Ship) (*response, *httpError) {
+	body := client.SellCargoJSONRequestBody{}
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	tg, err := s.tradeGood(ship, body.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	if body.Units <= 0 || s.cargoUnits(ship, body.Symbol) < body.Units {
+		return nil, fail(http.StatusBadRequest, ErrCodeBadRequest, "Ship %s does not have %d %s.", ship.Symbol, body.Units, body.Symbol)
+	}
+	s.addCargo(ship, body.Symbol, -body.Units)
+	transaction := s.transaction(ship, body.Symbol, body.Units, tg.SellPrice, client.SELL)
+	s.player.agent.Credits += transaction.TotalPrice
+	return data(201, map[string]interface{}{"agent": s.player.agent, "cargo": ship.Cargo, "transaction": transaction}), nil
+}
+
+func (s *Server) purchase(r *http.Request, ship *client.Ship) (*response, *httpError) {
+	body := client.PurchaseCargoJSONRequestBody{}
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	tg, err := s.tradeGood(ship, body.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	if body.Units <= 0 || ship.Cargo.Units+body.Units > ship.Cargo.Capacity {
+		return nil, fail(http.StatusBadRequest, ErrCodeCargoFull, "Ship %s can not hold %d more units.", ship.Symbol, body.Units)
+	}
+	if s.player.agent.Credits < body.Units*tg.PurchasePrice {
+		return nil, fail(http.StatusBadRequest, ErrCodeInsufficientFunds, "Agent has insufficient funds.")
+	}
+	s.addCargo(ship, body.Symbol, body.Units)
+	transaction := s.transaction(ship, body.Symbol, body.Units, tg.PurchasePrice, client.PURCHASE)
+	s.player.agent.Credits -= transaction.TotalPrice
+	return data(201, map[string]interface{}{"agent": s.player.agent, "cargo": ship.Cargo, "transaction": transaction}), nil
+}
+
+func (s *Server) refuel(ship *client.Ship) (*response, *httpError) {
+	tg, err := s.tradeGood(ship, string(client.TradeSymbolFUEL))
+	if err != nil {
+		return nil, err
+	}
+	missing := ship.Fuel.Capacity - ship.Fuel.Current
+	// fuel is sold by the market unit of 100 fuel
+	units := (missing + 99) / 100
+	if s.player.agent.Credits < units*tg.PurchasePrice {
+		return nil, fail(http.StatusBadRequest, ErrCodeInsufficientFunds, "Agent has insufficient funds.")
+	}
+	transaction := s.transaction(ship, string(client.TradeSymbolFUEL), units, tg.PurchasePrice, client.PURCHASE)
+	s.player.agent.Credits -= transaction.TotalPrice
+	ship.Fuel.Current = ship.Fuel.Capacity
+	return data(200, map[string]interface{}{"agent": s.player.agent, "fuel": ship.Fuel, "transaction": transaction}), nil
+}
+
+func (s *Server) jettison(r *http.Request, ship *client.Ship) (*response, *httpError) {
+	body := client.JettisonJSONRequestBody{}
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	if body.Units <= 0 || s.cargoUnits(ship, body.Symbol) < body.Units {
+		return nil, fail(http.StatusBadRequest, ErrCodeBadRequest, "Ship %s does not have %d %s.", ship.Symbol, body.Units, body.Symbol)
+	}
+	s.addCargo(ship, body.Symbol, -body.Units)
+	return data(200, map[string]interface{}{"cargo": ship.Cargo}), nil
+}
+
+// shipPresent reports whether one of our ships is at the waypoint, which makes
+// prices at its market and shipyard visible.
+func (s *Server) shipPresent(waypointSymbol string) bool {
+	for _, ship := range s.player.ships {
+		s.updateNav(ship)
+		if ship.Nav.WaypointSymbol == waypointSymbol && ship.Nav.Status != client.INTRANSIT {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) market(waypointSymbol string) (*response, *httpError) {
+	market, ok := s.markets[waypointSymbol]
+	if !ok {
+		return nil, fail(http.StatusNotFound, 404, "Waypoint %s does not have a market.", waypointSymbol)
+	}
+	m := *market
+	if !s.shipPresent(waypointSymbol) {
+		m.TradeGoods = nil
+		m.Transactions = nil
+	}
+	return data(200, m), nil
+}
+
+func (s *Server) shipyard(waypointSymbol string) (*response, *httpError) {
+	shipyard, ok := s.shipyards[waypointSymbol]
+	if !ok {
+		return nil, fail(http.StatusNotFound, 404, "Waypoint %s does not have a shipyard.", waypointSymbol)
+	}
+	sy := *shipyard
+	if !s.shipPresent(waypointSymbol) {
+		sy.Ships = nil
+		sy.Transactions = nil
+	}
+	return data(200, sy), nil
+}
+
+func (s *Server) purchaseShip(r *http.Request) (*response, *httpError) {
+	body := client.PurchaseShipJSONRequestBody{}
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	shipyard, ok := s.shipyards[body.WaypointSymbol]
+	if !ok {
+		return nil, fail(http.StatusNotFound, 404, "Waypoint %s does not have a shipyard.", body.WaypointSymbol)
+	}
+	if !s.shipPresent(body.WaypointSymbol) {
+		return nil, fail(http.StatusBadRequest, ErrCodeBadRequest, "No ship present at %s to purchase from.", body.WaypointSymbol)
+	}
+	for _, offer := range *shipyard.Ships {
+		if offer.Type == nil || *offer.Type != body.ShipType {
+			continue
+		}
+		if s.player.agent.Credits < offer.PurchasePrice {
+			return nil, fail(http.StatusBadRequest, ErrCodeInsufficientFunds, "Agent has insufficient funds.")
+		}
+		role := client.ShipRoleEXCAVATOR
+		if body.ShipType == client.SHIPPROBE {
+			role = client.ShipRoleSATELLITE
+		}
+		ship := s.addShip(role, body.WaypointSymbol)
+		s.player.agent.Credits -= offer.PurchasePrice
+		transaction := client.ShipyardTransaction{
+			AgentSymbol:    s.player.agent.Symbol,
+			ShipSymbol:     ship.Symbol,
+			WaypointSymbol: body.WaypointSymbol,
+			Price:          offer.PurchasePrice,
+			Timestamp:      s.opts.Clock.Now(),
+		}
+		*shipyard.Transactions = append(*shipyard.Transactions, transaction)
+		return data(201, map[string]interface{}{"agent": s.player.agent, "ship": ship, "transaction": transaction}), nil
+	}
+	return nil, fail(http.StatusBadRequest, ErrCodeBadRequest, "Shipyard %s does not sell %s.", body.WaypointSymbol, body.ShipType)
+}
+
+func (s *Server) acceptContract(contract *client.Contract) (*response, *httpError) {
+	if contract.Accepted {
+		return nil, fail(http.StatusBadRequest, ErrCodeContract, "Contract %s has already been accepted.", contract.Id)
+	}
+	if !contract.Expiration.After(s.opts.Clock.Now()) {
+		return nil, fail(http.StatusBadRequest, ErrCodeContract, "Contract %s offer has expired.", contract.Id)
+	}
+	contract.Accepted = true
+	s.player.agent.Credits += contract.Terms.Payment.OnAccepted
+	return data(200, map[string]interface{}{"agent": s.player.agent, "contract": contract}), nil
+}
+
+func (s *Server) deliverContract(r *http.Request, contract *client.Contract) (*response, *httpError) {
+	body := client.DeliverContractJSONRequestBody{}
+	if err := decode(r, &body); err != nil {
+		return nil, err
+	}
+	if !contract.Accepted || contract.Fulfilled {
+		return nil, fail(http.StatusBadRequest, ErrCodeContract, "Contract %s is not active.", contract.Id)
+	}
+	ship := s.ship(body.ShipSymbol)
+	if ship == nil {
+		return nil, fail(http.StatusNotFound, 404, "Ship %s not found", body.ShipSymbol)
+	}
+	s.updateNav(ship)
+	if err := s.requireStatus(ship, client.DOCKED); err != nil {
+		return nil, err
+	}
+	for i, deliver := range *contract.Terms.Deliver {
+		if deliver.TradeSymbol != body.TradeSymbol {
+			continue
+		}
+		if deliver.DestinationSymbol != ship.Nav.WaypointSymbol {
+			return nil, fail(http.StatusBadRequest, ErrCodeContract, "%s must be delivered to %s.", deliver.TradeSymbol, deliver.DestinationSymbol)
+		}
+		if body.Units <= 0 || s.cargoUnits(ship, body.TradeSymbol) < body.Units {
+			return nil, fail(http.StatusBadRequest, ErrCodeBadRequest, "Ship %s does not have %d %s.", ship.Symbol, body.Units, body.TradeSymbol)
+		}
+		if deliver.UnitsFulfilled+body.Units > deliver.UnitsRequired {
+			return nil, fail(http.StatusBadRequest, ErrCodeContract, "Contract %s only needs %d more %s.", contract.Id, deliver.UnitsRequired-deliver.UnitsFulfilled, deliver.TradeSymbol)
+		}
+		s.addCargo(ship, body.TradeSymbol, -body.Units)
+		(*contract.Terms.Deliver)[i].UnitsFulfilled += body.Units
+		return data(200, map[string]interface{}{"contract": contract, "cargo": ship.Cargo}), nil
+	}
+	return nil, fail(http.StatusBadRequest, ErrCodeContract, "Contract %s does not require %s.", contract.Id, body.TradeSymbol)
+}
+
+func (s *Server) fulfillContract(contract *client.Contract) (*response, *httpError) {
+	if !contract.Accepted || contract.Fulfilled {
+		return nil, fail(http.StatusBadRequest, ErrCodeContract, "Contract %s is not active.", contract.Id)
+	}
+	for _, deliver := range *contract.Terms.Deliver {
+		if deliver.UnitsFulfilled < deliver.UnitsRequired {
+			return nil, fail(http.StatusBadRequest, ErrCodeContract, "Contract %s still needs %d %s.", contract.Id, deliver.UnitsRequired-deliver.UnitsFulfilled, deliver.TradeSymbol)
+		}
+	}
+	contract.Fulfilled = true
+	s.player.agent.Credits += contract.Terms.Payment.OnFulfilled
+	s.addContract()
+	return data(200, map[string]interface{}{"agent": s.player.agent, "contract": contract}), nil
+}
@@ -0,0 +1,378 @@
+// Package sim is an offline SpaceTraders server. It implements the paths the
+// generated client calls on top of a small deterministic universe, with travel
+// times and cooldowns driven by an injectable clock, so the bot can be run
+// end-to-end without the live API.
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+	"github.com/Dutchy-/spacetrader-go/main/clock"
+)
+
+// Error codes as returned by the live API.
+const (
+	ErrCodeCooldown           = 4000
+	ErrCodeInTransit          = 4214
+	ErrCodeSurveyVerification = 4221
+	ErrCodeSurveyExpired      = 4222
+	ErrCodeSurveyExhausted    = 4224
+	ErrCodeCargoFull          = 4228
+	ErrCodeNotInOrbit         = 4236
+	ErrCodeNotDocked          = 4244
+	ErrCodeInsufficientFuel   = 4203
+	ErrCodeInsufficientFunds  = 4600
+	ErrCodeMarketTrade        = 4602
+	ErrCodeContract           = 4500
+	ErrCodeUnauthorized       = 4100
+	ErrCodeBadRequest         = 400
+)
+
+// Options configure the simulator. Zero values are replaced by defaults that
+// roughly match the live game.
+type Options struct {
+	Clock clock.Clock
+	// Seed makes surveys and extraction yields reproducible
+	Seed int64
+	// Token is the bearer token the requests of the first agent must carry, if
+	// set. Without it every request is made by the first agent.
+	Token string
+	// TravelTime is the time it takes to travel one unit of distance at speed 30
+	TravelTime      time.Duration
+	ExtractCooldown time.Duration
+	SurveyCooldown  time.Duration
+	ScanCooldown    time.Duration
+	SurveyLifetime  time.Duration
+}
+
+func (opts *Options) setDefaults() {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real{}
+	}
+	if opts.TravelTime == 0 {
+		opts.TravelTime = time.Second
+	}
+	if opts.ExtractCooldown == 0 {
+		opts.ExtractCooldown = 70 * time.Second
+	}
+	if opts.SurveyCooldown == 0 {
+		opts.SurveyCooldown = 70 * time.Second
+	}
+	if opts.ScanCooldown == 0 {
+		opts.ScanCooldown = 70 * time.Second
+	}
+	if opts.SurveyLifetime == 0 {
+		opts.SurveyLifetime = 15 * time.Minute
+	}
+}
+
+type survey struct {
+	client.Survey
+	Remaining int
+}
+
+// player is an agent with everything it owns.
+type player struct {
+	agent     client.Agent
+	ships     []*client.Ship
+	contracts []*client.Contract
+}
+
+// Server is an http.Handler serving the SpaceTraders API from memory. Mount it at
+// the root of the server URL handed to the client.
+type Server struct {
+	mu        sync.Mutex
+	opts      Options
+	rng       *rand.Rand
+	system    client.System
+	waypoints []client.Waypoint
+	deposits  map[string][]string
+	markets   map[string]*client.Market
+	shipyards map[string]*client.Shipyard
+	// players maps the tokens to the agents, who share the universe
+	players map[string]*player
+	// player is the agent the request being served is made by
+	player    *player
+	cooldowns map[string]client.Cooldown
+	surveys   map[string]*survey
+}
+
+func New(opts Options) *Server {
+	opts.setDefaults()
+	s := &Server{
+		opts:      opts,
+		rng:       rand.New(rand.NewSource(opts.Seed)),
+		deposits:  make(map[string][]string),
+		markets:   make(map[string]*client.Market),
+		shipyards: make(map[string]*client.Shipyard),
+		players:   make(map[string]*player),
+		cooldowns: make(map[string]client.Cooldown),
+		surveys:   make(map[string]*survey),
+	}
+	s.createUniverse()
+	s.addPlayer(AGENT, opts.Token)
+	return s
+}
+
+// AddAgent adds another agent with its own command ship and contract, for the
+// requests carrying the token. Ship symbols start with the agent symbol, so
+// they must differ between agents.
+func (s *Server) AddAgent(symbol string, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addPlayer(symbol, token)
+}
+
+// Agent returns a copy of the first simulated agent.
+func (s *Server) Agent() client.Agent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.players[s.opts.Token].agent
+}
+
+// Ships returns a copy of all ships of the first agent with their nav brought up
+// to date.
+func (s *Server) Ships() []client.Ship {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ships := []client.Ship{}
+	for _, ship := range s.players[s.opts.Token].ships {
+		s.updateNav(ship)
+		ships = append(ships, *ship)
+	}
+	return ships
+}
+
+// Contracts returns a copy of all contracts of the first agent.
+func (s *Server) Contracts() []client.Contract {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contracts := []client.Contract{}
+	for _, contract := range s.players[s.opts.Token].contracts {
+		contracts = append(contracts, *contract)
+	}
+	return contracts
+}
+
+type apiError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// httpError is returned by handlers to fail a request with an API error body.
+type httpError struct {
+	status int
+	apiError
+}
+
+func (e *httpError) Error() string {
+	return e.Message
+}
+
+func fail(status int, code int, format string, args ...interface{}) *httpError {
+	return &httpError{status: status, apiError: apiError{Code: code, Message: fmt.Sprintf(format, args...)}}
+}
+
+type response struct {
+	status int
+	body   interface{}
+}
+
+func data(status int, v interface{}) *response {
+	return &response{status: status, body: map[string]interface{}{"data": v}}
+}
+
+func list(v interface{}, total int) *response {
+	return &response{status: 200, body: map[string]interface{}{
+		"data": v,
+		"meta": client.Meta{Total: total, Page: 1, Limit: total},
+	}}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var resp *response
+	var err *httpError
+	player, ok := s.players[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok && (s.opts.Token == "" || r.URL.Path == "/register") {
+		player, ok = s.players[s.opts.Token], true
+	}
+	if !ok {
+		err = fail(http.StatusUnauthorized, ErrCodeUnauthorized, "Failed to parse token. Token reset_date does not match the server.")
+	} else {
+		s.player = player
+		resp, err = s.route(r, parts)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(err.status)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": err.apiError})
+		return
+	}
+	if resp.body == nil {
+		w.WriteHeader(resp.status)
+		return
+	}
+	w.WriteHeader(resp.status)
+	_ = json.NewEncoder(w).Encode(resp.body)
+}
+
+func (s *Server) route(r *http.Request, parts []string) (*response, *httpError) {
+	get := r.Method == http.MethodGet
+	post := r.Method == http.MethodPost
+	switch {
+	case len(parts) == 1 && parts[0] == "register" && post:
+		return s.register(r)
+	case len(parts) == 2 && parts[0] == "my" && parts[1] == "agent" && get:
+		return data(200, s.player.agent), nil
+	case len(parts) >= 2 && parts[0] == "my" && parts[1] == "contracts":
+		return s.routeContracts(r, parts[2:])
+	case len(parts) >= 2 && parts[0] == "my" && parts[1] == "ships":
+		return s.routeShips(r, parts[2:])
+	case len(parts) >= 2 && parts[0] == "systems":
+		return s.routeSystems(r, parts[1:])
+	}
+	return nil, fail(http.StatusNotFound, 404, "Route %s %s not found", r.Method, r.URL.Path)
+}
+
+func (s *Server) routeContracts(r *http.Request, parts []string) (*response, *httpError) {
+	if len(parts) == 0 && r.Method == http.MethodGet {
+		contracts := []client.Contract{}
+		for _, c := range s.player.contracts {
+			contracts = append(contracts, *c)
+		}
+		return list(contracts, len(contracts)), nil
+	}
+	if len(parts) == 0 {
+		return nil, fail(http.StatusNotFound, 404, "Route %s %s not found", r.Method, r.URL.Path)
+	}
+	contract := s.contract(parts[0])
+	if contract == nil {
+		return nil, fail(http.StatusNotFound, 404, "Contract %s not found", parts[0])
+	}
+	if len(parts) == 1 && r.Method == http.MethodGet {
+		return data(200, contract), nil
+	}
+	if len(parts) != 2 || r.Method != http.MethodPost {
+		return nil, fail(http.StatusNotFound, 404, "Route %s %s not found", r.Method, r.URL.Path)
+	}
+	switch parts[1] {
+	case "accept":
+		return s.acceptContract(contract)
+	case "deliver":
+		return s.deliverContract(r, contract)
+	case "fulfill":
+		return s.fulfillContract(contract)
+	}
+	return nil, fail(http.StatusNotFound, 404, "Route %s %s not found", r.Method, r.URL.Path)
+}
+
+func (s *Server) routeShips(r *http.Request, parts []string) (*response, *httpError) {
+	if len(parts) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			ships := []client.Ship{}
+			for _, ship := range s.player.ships {
+				s.updateNav(ship)
+				ships = append(ships, *ship)
+			}
+			return list(ships, len(ships)), nil
+		case http.MethodPost:
+			return s.purchaseShip(r)
+		}
+		return nil, fail(http.StatusNotFound, 404, "Route %s %s not found", r.Method, r.URL.Path)
+	}
+	ship := s.ship(parts[0])
+	if ship == nil {
+		return nil, fail(http.StatusNotFound, 404, "Ship %s not found", parts[0])
+	}
+	s.updateNav(ship)
+	if len(parts) == 1 && r.Method == http.MethodGet {
+		return data(200, ship), nil
+	}
+	action := strings.Join(parts[1:], "/")
+	if r.Method == http.MethodGet {
+		switch action {
+		case "nav":
+			return data(200, ship.Nav), nil
+		case "cargo":
+			return data(200, ship.Cargo), nil
+		case "cooldown":
+			cooldown, ok := s.cooldown(ship)
+			if !ok {
+				return &response{status: http.StatusNoContent}, nil
+			}
+			return data(200, cooldown), nil
+		}
+	} else if r.Method == http.MethodPost {
+		switch action {
+		case "dock":
+			return s.dock(ship)
+		case "orbit":
+			return s.orbit(ship)
+		case "navigate":
+			return s.navigate(r, ship)
+		case "extract":
+			return s.extract(r, ship)
+		case "survey":
+			return s.survey(ship)
+		case "scan/waypoints":
+			return s.scanWaypoints(ship)
+		case "refuel":
+			return s.refuel(ship)
+		case "sell":
+			return s.sell(r, ship)
+		case "purchase":
+			return s.purchase(r, ship)
+		case "jettison":
+			return s.jettison(r, ship)
+		}
+	}
+	return nil, fail(http.StatusNotFound, 404, "Route %s %s not found", r.Method, r.URL.Path)
+}
+
+func (s *Server) routeSystems(r *http.Request, parts []string) (*response, *httpError) {
+	if r.Method != http.MethodGet || parts[0] != s.system.Symbol {
+		return nil, fail(http.StatusNotFound, 404, "System %s not found", parts[0])
+	}
+	switch len(parts) {
+	case 1:
+		return data(200, s.system), nil
+	case 2:
+		if parts[1] == "waypoints" {
+			return list(s.waypoints, len(s.waypoints)), nil
+		}
+	case 3, 4:
+		wp := s.waypoint(parts[2])
+		if parts[1] != "waypoints" || wp == nil {
+			return nil, fail(http.StatusNotFound, 404, "Waypoint %s not found", parts[2])
+		}
+		if len(parts) == 3 {
+			return data(200, wp), nil
+		}
+		switch parts[3] {
+		case "market":
+			return s.market(wp.Symbol)
+		case "shipyard":
+			return s.shipyard(wp.Symbol)
+		}
+	}
+	return nil, fail(http.StatusNotFound, 404, "Route %s %s not found", r.Method, r.URL.Path)
+}
+
+func decode(r *http.Request, v interface{}) *httpError {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fail(http.StatusBadRequest, ErrCodeBadRequest, "Invalid request body: %v", err)
+	}
+	return nil
+}
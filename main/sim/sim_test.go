@@ -0,0 +1,46 @@
+package sim
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUnknownRoutes(t *testing.T) {
+	server := New(Options{})
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPost, "/my/contracts"},
+		{http.MethodDelete, "/my/ships"},
+		{http.MethodPost, "/my/contracts/unknown/accept"},
+		{http.MethodPost, "/my/ships/UNKNOWN/dock"},
+	} {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(route.method, route.path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: got status %d, want 404", route.method, route.path, w.Code)
+		}
+	}
+}
+
+func TestAgentsByToken(t *testing.T) {
+	server := New(Options{Token: "token"})
+	server.AddAgent("RIVAL", "rival-token")
+	for _, test := range []struct {
+		token  string
+		status int
+		agent  string
+	}{
+		{"token", http.StatusOK, `"symbol":"SIMULANT"`},
+		{"rival-token", http.StatusOK, `"symbol":"RIVAL"`},
+		{"wrong-token", http.StatusUnauthorized, ""},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/my/agent", nil)
+		r.Header.Set("Authorization", "Bearer "+test.token)
+		server.ServeHTTP(w, r)
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.agent) {
+			t.Errorf("token %s: got status %d and %s, want %d and %s", test.token, w.Code, w.Body.String(), test.status, test.agent)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+const (
+	SYSTEM       = "X1-SIM"
+	HEADQUARTERS = "X1-SIM-A1"
+	STATION      = "X1-SIM-A2"
+	ASTEROIDS    = "X1-SIM-B1"
+	OUTPOST      = "X1-SIM-C1"
+	AGENT        = "SIMULANT"
+	FACTION      = "COSMIC"
+)
+
+func trait(symbol client.WaypointTraitSymbol) client.WaypointTrait {
+	return client.WaypointTrait{Symbol: symbol, Name: string(symbol), Description: string(symbol)}
+}
+
+func tradeGood(symbol client.TradeSymbol, purchase int, sell int) client.MarketTradeGood {
+	return client.MarketTradeGood{
+		Symbol:        string(symbol),
+		PurchasePrice: purchase,
+		SellPrice:     sell,
+		Supply:        client.MarketTradeGoodSupplyMODERATE,
+		TradeVolume:   100,
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// createUniverse builds the single system the simulator consists of: a planet
+// with a market and shipyard, an orbital station buying ores, an asteroid field
+// and a remote outpost without a market.
+func (s *Server) createUniverse() {
+	faction := &client.WaypointFaction{Symbol: FACTION}
+	s.waypoints = []client.Waypoint{
+		{Symbol: HEADQUARTERS, SystemSymbol: SYSTEM, Type: client.WaypointTypePLANET, X: 10, Y: 5, Faction: faction,
+			Orbitals: []client.WaypointOrbital{{Symbol: STATION}},
+			Traits:   []client.WaypointTrait{trait(client.WaypointTraitSymbolMARKETPLACE), trait(client.WaypointTraitSymbolSHIPYARD)}},
+		{Symbol: STATION, SystemSymbol: SYSTEM, Type: client.WaypointTypeORBITALSTATION, X: 10, Y: 5, Faction: faction,
+			Orbitals: []client.WaypointOrbital{},
+			Traits:   []client.WaypointTrait{trait(client.WaypointTraitSymbolMARKETPLACE)}},
+		{Symbol: ASTEROIDS, SystemSymbol: SYSTEM, Type: client.WaypointTypeASTEROIDFIELD, X: -20, Y: 30,
+			Orbitals: []client.WaypointOrbital{},
+			Traits:   []client.WaypointTrait{trait(client.WaypointTraitSymbolMINERALDEPOSITS)}},
+		{Symbol: OUTPOST, SystemSymbol: SYSTEM, Type: client.WaypointTypePLANET, X: 60, Y: -40,
+			Orbitals: []client.WaypointOrbital{},
+			Traits:   []client.WaypointTrait{trait(client.WaypointTraitSymbolBARREN)}},
+	}
+	s.system = client.System{Symbol: SYSTEM, SectorSymbol: "X1", Type: client.SystemTypeREDSTAR, Factions: []client.SystemFaction{{Symbol: FACTION}}}
+	for _, wp := range s.waypoints {
+		s.system.Waypoints = append(s.system.Waypoints, client.SystemWaypoint{Symbol: wp.Symbol, Type: wp.Type, X: wp.X, Y: wp.Y})
+	}
+	s.deposits[ASTEROIDS] = []string{
+		string(client.TradeSymbolIRONORE), string(client.TradeSymbolCOPPERORE), string(client.TradeSymbolALUMINUMORE),
+		string(client.TradeSymbolQUARTZSAND), string(client.TradeSymbolSILICONCRYSTALS), string(client.TradeSymbolICEWATER),
+	}
+
+	s.markets[HEADQUARTERS] = &client.Market{
+		Symbol:   HEADQUARTERS,
+		Imports:  []client.TradeGood{{Symbol: client.TradeSymbolIRONORE}, {Symbol: client.TradeSymbolCOPPERORE}},
+		Exports:  []client.TradeGood{{Symbol: client.TradeSymbolFUEL}},
+		Exchange: []client.TradeGood{{Symbol: client.TradeSymbolICEWATER}},
+		TradeGoods: &[]client.MarketTradeGood{
+			tradeGood(client.TradeSymbolIRONORE, 45, 38),
+			tradeGood(client.TradeSymbolCOPPERORE, 60, 50),
+			tradeGood(client.TradeSymbolFUEL, 120, 100),
+			tradeGood(client.TradeSymbolICEWATER, 14, 10),
+		},
+		Transactions: &[]client.MarketTransaction{},
+	}
+	s.markets[STATION] = &client.Market{
+		Symbol:   STATION,
+		Imports:  []client.TradeGood{{Symbol: client.TradeSymbolALUMINUMORE}, {Symbol: client.TradeSymbolQUARTZSAND}, {Symbol: client.TradeSymbolSILICONCRYSTALS}},
+		Exports:  []client.TradeGood{},
+		Exchange: []client.TradeGood{{Symbol: client.TradeSymbolFUEL}},
+		TradeGoods: &[]client.MarketTradeGood{
+			tradeGood(client.TradeSymbolALUMINUMORE, 70, 58),
+			tradeGood(client.TradeSymbolQUARTZSAND, 25, 20),
+			tradeGood(client.TradeSymbolSILICONCRYSTALS, 40, 33),
+			tradeGood(client.TradeSymbolFUEL, 125, 105),
+		},
+		Transactions: &[]client.MarketTransaction{},
+	}
+
+	drone := client.SHIPMININGDRONE
+	probe := client.SHIPPROBE
+	s.shipyards[HEADQUARTERS] = &client.Shipyard{
+		Symbol: HEADQUARTERS,
+		ShipTypes: []struct {
+			Type *client.ShipType `json:"type,omitempty"`
+		}{{Type: &drone}, {Type: &probe}},
+		Ships: &[]client.ShipyardShip{
+			shipyardShip(newShip("", client.ShipRoleEXCAVATOR, HEADQUARTERS), drone, 80000),
+			shipyardShip(newShip("", client.ShipRoleSATELLITE, HEADQUARTERS), probe, 25000),
+		},
+		Transactions: &[]client.ShipyardTransaction{},
+	}
+
+}
+
+// addPlayer adds an agent at the headquarters with a command ship and a
+// contract offered.
+func (s *Server) addPlayer(symbol string, token string) {
+	s.player = &player{agent: client.Agent{AccountId: "sim-account-" + symbol, Symbol: symbol, Credits: 100000, Headquarters: HEADQUARTERS}}
+	s.players[token] = s.player
+	s.addShip(client.ShipRoleCOMMAND, HEADQUARTERS)
+	s.addContract()
+}
+
+func shipyardShip(ship client.Ship, shipType client.ShipType, price int) client.ShipyardShip {
+	return client.ShipyardShip{
+		Type:          &shipType,
+		Name:          string(shipType),
+		Description:   string(shipType),
+		Engine:        ship.Engine,
+		Frame:         ship.Frame,
+		Reactor:       ship.Reactor,
+		Modules:       ship.Modules,
+		Mounts:        ship.Mounts,
+		PurchasePrice: price,
+	}
+}
+
+// newShip returns a ship as it comes out of the shipyard for the role.
+func newShip(symbol string, role client.ShipRole, waypointSymbol string) client.Ship {
+	mount := func(symbol client.ShipMountSymbol, strength int) client.ShipMount {
+		return client.ShipMount{Symbol: symbol, Name: string(symbol), Strength: intPtr(strength)}
+	}
+	ship := client.Ship{
+		Symbol:       symbol,
+		Registration: client.ShipRegistration{Name: symbol, FactionSymbol: FACTION, Role: role},
+		Crew:         client.ShipCrew{Capacity: 80, Current: 57, Required: 57, Morale: 100, Rotation: client.STRICT},
+		Reactor:      client.ShipReactor{Symbol: client.REACTORFISSIONI, Name: "Fission Reactor I", PowerOutput: 31},
+		Engine:       client.ShipEngine{Symbol: client.ShipEngineSymbolENGINEIONDRIVEI, Name: "Ion Drive I", Speed: 30},
+		Frame:        client.ShipFrame{Symbol: client.FRAMEFRIGATE, Name: "Frigate", FuelCapacity: 1200, ModuleSlots: 8, MountingPoints: 5},
+		Modules:      []client.ShipModule{{Symbol: client.MODULECARGOHOLDI, Name: "Cargo Hold", Capacity: intPtr(30)}},
+		Mounts: []client.ShipMount{
+			mount(client.MOUNTSENSORARRAYI, 1),
+			mount(client.MOUNTMININGLASERI, 10),
+			mount(client.MOUNTSURVEYORI, 1),
+		},
+		Cargo: client.ShipCargo{Capacity: 60, Inventory: []client.ShipCargoItem{}},
+		Fuel:  client.ShipFuel{Capacity: 1200, Current: 1200},
+	}
+	switch role {
+	case client.ShipRoleEXCAVATOR:
+		ship.Frame = client.ShipFrame{Symbol: client.FRAMEDRONE, Name: "Drone", FuelCapacity: 100, ModuleSlots: 2, MountingPoints: 2}
+		ship.Engine = client.ShipEngine{Symbol: client.ShipEngineSymbolENGINEIMPULSEDRIVEI, Name: "Impulse Drive I", Speed: 10}
+		ship.Mounts = []client.ShipMount{mount(client.MOUNTMININGLASERI, 10)}
+		ship.Cargo.Capacity = 30
+		ship.Fuel = client.ShipFuel{Capacity: 100, Current: 100}
+	case client.ShipRoleSATELLITE:
+		ship.Frame = client.ShipFrame{Symbol: client.FRAMEPROBE, Name: "Probe", ModuleSlots: 0, MountingPoints: 0}
+		ship.Reactor = client.ShipReactor{Symbol: client.REACTORSOLARI, Name: "Solar Reactor I", PowerOutput: 3}
+		ship.Mounts = []client.ShipMount{}
+		ship.Modules = []client.ShipModule{}
+		ship.Cargo.Capacity = 0
+		ship.Fuel = client.ShipFuel{}
+	}
+	return ship
+}
+
+func (s *Server) addShip(role client.ShipRole, waypointSymbol string) *client.Ship {
+	symbol := fmt.Sprintf("%s-%d", s.player.agent.Symbol, len(s.player.ships)+1)
+	ship := newShip(symbol, role, waypointSymbol)
+	wp := s.routeWaypoint(waypointSymbol)
+	now := s.opts.Clock.Now()
+	ship.Nav = client.ShipNav{
+		SystemSymbol:   SYSTEM,
+		WaypointSymbol: waypointSymbol,
+		Status:         client.DOCKED,
+		FlightMode:     client.CRUISE,
+		Route:          client.ShipNavRoute{Departure: wp, Destination: wp, DepartureTime: now, Arrival: now},
+	}
+	s.player.ships = append(s.player.ships, &ship)
+	return &ship
+}
+
+// addContract offers a new procurement contract for one of the ores.
+func (s *Server) addContract() *client.Contract {
+	goods := []client.TradeSymbol{client.TradeSymbolIRONORE, client.TradeSymbolCOPPERORE, client.TradeSymbolALUMINUMORE}
+	good := goods[len(s.player.contracts)%len(goods)]
+	now := s.opts.Clock.Now()
+	contract := &client.Contract{
+		Id:            fmt.Sprintf("sim-contract-%d", len(s.player.contracts)+1),
+		FactionSymbol: FACTION,
+		Type:          client.ContractTypePROCUREMENT,
+		Expiration:    now.Add(24 * time.Hour),
+		Terms: client.ContractTerms{
+			Deadline: now.Add(7 * 24 * time.Hour),
+			Payment:  client.ContractPayment{OnAccepted: 10000, OnFulfilled: 40000},
+			Deliver: &[]client.ContractDeliverGood{{
+				TradeSymbol:       string(good),
+				DestinationSymbol: STATION,
+				UnitsRequired:     100,
+			}},
+		},
+	}
+	s.player.contracts = append(s.player.contracts, contract)
+	return contract
+}
+
+func (s *Server) waypoint(symbol string) *client.Waypoint {
+	for i, wp := range s.waypoints {
+		if wp.Symbol == symbol {
+			return &s.waypoints[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) routeWaypoint(symbol string) client.ShipNavRouteWaypoint {
+	wp := s.waypoint(symbol)
+	return client.ShipNavRouteWaypoint{Symbol: wp.Symbol, SystemSymbol: wp.SystemSymbol, Type: wp.Type, X: wp.X, Y: wp.Y}
+}
+
+func (s *Server) ship(symbol string) *client.Ship {
+	for _, ship := range s.player.ships {
+		if ship.Symbol == symbol {
+			return ship
+		}
+	}
+	return nil
+}
+
+func (s *Server) contract(id string) *client.Contract {
+	for _, contract := range s.player.contracts {
+		if contract.Id == id {
+			return contract
+		}
+	}
+	return nil
+}
+
+func distance(a client.ShipNavRouteWaypoint, b client.ShipNavRouteWaypoint) float64 {
+	return math.Sqrt(math.Pow(float64(a.X-b.X), 2) + math.Pow(float64(a.Y-b.Y), 2))
+}
+
+// updateNav lands the ship in orbit at its destination once it has arrived.
+func (s *Server) updateNav(ship *client.Ship) {
+	if ship.Nav.Status == client.INTRANSIT && !s.opts.Clock.Now().Before(ship.Nav.Route.Arrival) {
+		ship.Nav.Status = client.INORBIT
+	}
+}
+
+// cooldown returns the reactor cooldown of the ship, if it has one.
+func (s *Server) cooldown(ship *client.Ship) (client.Cooldown, bool) {
+	cooldown, ok := s.cooldowns[ship.Symbol]
+	now := s.opts.Clock.Now()
+	if !ok || !cooldown.Expiration.After(now) {
+		return client.Cooldown{}, false
+	}
+	cooldown.RemainingSeconds = int(math.Ceil(cooldown.Expiration.Sub(now).Seconds()))
+	return cooldown, true
+}
+
+func (s *Server) startCooldown(ship *client.Ship, d time.Duration) client.Cooldown {
+	cooldown := client.Cooldown{
+		ShipSymbol:       ship.Symbol,
+		Expiration:       s.opts.Clock.Now().Add(d),
+		RemainingSeconds: int(d.Seconds()),
+		TotalSeconds:     int(d.Seconds()),
+	}
+	s.cooldowns[ship.Symbol] = cooldown
+	return cooldown
+}
+
+func (s *Server) cargoUnits(ship *client.Ship, good string) int {
+	for _, item := range ship.Cargo.Inventory {
+		if item.Symbol == good {
+			return item.Units
+		}
+	}
+	return 0
+}
+
+// addCargo adds units of the good to the cargo hold. Negative units remove cargo.
+func (s *Server) addCargo(ship *client.Ship, good string, units int) {
+	ship.Cargo.Units += units
+	for i, item := range ship.Cargo.Inventory {
+		if item.Symbol == good {
+			ship.Cargo.Inventory[i].Units += units
+			if ship.Cargo.Inventory[i].Units <= 0 {
+				ship.Cargo.Inventory = append(ship.Cargo.Inventory[:i], ship.Cargo.Inventory[i+1:]...)
+			}
+			return
+		}
+	}
+	if units > 0 {
+		ship.Cargo.Inventory = append(ship.Cargo.Inventory, client.ShipCargoItem{Symbol: good, Name: good, Description: good, Units: units})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/main/clock"
+	"github.com/Dutchy-/spacetrader-go/main/sim"
+)
+
+// simServer starts a simulator on the fake clock, whose first agent uses the
+// token "token".
+func simServer(t *testing.T, fake *clock.Fake) (*sim.Server, *httptest.Server) {
+	simulator := sim.New(sim.Options{Clock: fake, Seed: 1, Token: "token"})
+	server := httptest.NewServer(simulator)
+	t.Cleanup(server.Close)
+	return simulator, server
+}
+
+// simAccount returns an account connected to the simulator with the token.
+func simAccount(t *testing.T, name string, server *httptest.Server, token string, strategy *Strategy) *Account {
+	account := NewAccount(AgentConfig{Name: name, Rate: 1000, Burst: 100}, strategy)
+	account.Token = token
+	account.StatePath = filepath.Join(t.TempDir(), "game.state.json")
+	if err := account.Connect(server.URL, server.Client()); err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+// simGame starts a game for the agent of the token on the simulator, with the
+// agent and ships loaded.
+func simGame(t *testing.T, name string, server *httptest.Server, token string, fake *clock.Fake, strategy *Strategy) *Game {
+	game := NewGame(simAccount(t, name, server, token, strategy))
+	game.SetClock(fake)
+	game.Account.Events.Subscribe(game.Handle)
+	if err := game.InitAgent(); err != nil {
+		t.Fatal(err)
+	}
+	if err := game.InitShips(); err != nil {
+		t.Fatal(err)
+	}
+	return game
+}
+
+// simMiner returns the command ship of the simulator, which mines.
+func simMiner(t *testing.T, game *Game) *Miner {
+	for _, ship := range game.State.Ships {
+		if miner, ok := ship.(*Miner); ok && miner.Symbol == "SIMULANT-1" {
+			return miner
+		}
+	}
+	t.Fatal("simulator has no SIMULANT-1 miner")
+	return nil
+}
+
+// TestMinerAgainstSimulator plays the game loop against the simulator on a
+// fake clock until the command ship has extracted, sold and refueled.
+func TestMinerAgainstSimulator(t *testing.T) {
+	fake := clock.NewFake(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+	strategy := DefaultStrategy()
+	// refuel as soon as any fuel was used
+	strategy.Miner.LowFuel = 1
+	_, server := simServer(t, fake)
+	game := simGame(t, "test", server, "token", fake, &strategy)
+	miner := simMiner(t, game)
+	seen := map[string]int{}
+	refuelCost := 0
+	game.Account.Events.Subscribe(func(event Event) {
+		switch event := event.(type) {
+		case Extracted:
+			seen["extracted"]++
+		case Sold:
+			seen["sold"]++
+		case Refueled:
+			seen["refueled"]++
+			refuelCost += event.Cost
+		}
+	})
+	startCredits := game.State.Agent.Credits
+	for step := 0; step < 20000 && (seen["extracted"] == 0 || seen["sold"] == 0 || seen["refueled"] == 0); step++ {
+		game.Step()
+	}
+	if seen["extracted"] == 0 || seen["sold"] == 0 || seen["refueled"] == 0 {
+		t.Fatalf("miner did not extract, sell and refuel: %v, stuck in %s", seen, miner.State)
+	}
+	if refuelCost <= 0 {
+		t.Errorf("refueling cost %d credits", refuelCost)
+	}
+	if game.State.Agent.Credits == startCredits {
+		t.Errorf("credits did not change from %d", startCredits)
+	}
+}
+
+// sales counts the sales in the ledger.
+func sales(ledger *Ledger) int {
+	n := 0
+	for _, entry := range ledger.Entries {
+		if entry.Kind == LEDGER_SELL {
+			n++
+		}
+	}
+	return n
+}
+
+// TestAgentsKeepTheirOwnState plays two agents on one simulator until both
+// sold, and checks that their credits, ships and ledgers stay apart, while a
+// third agent with a wrong token fails to start without stopping them.
+func TestAgentsKeepTheirOwnState(t *testing.T) {
+	fake := clock.NewFake(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+	strategy := DefaultStrategy()
+	simulator, server := simServer(t, fake)
+	simulator.AddAgent("RIVAL", "rival-token")
+	first := simGame(t, "first", server, "token", fake, &strategy)
+	second := simGame(t, "second", server, "rival-token", fake, &strategy)
+	broken := NewGame(simAccount(t, "broken", server, "wrong-token", &strategy))
+	broken.SetClock(fake)
+	if err := broken.Run(); err == nil {
+		t.Fatal("game with a wrong token started")
+	}
+
+	games := map[string]*Game{"SIMULANT": first, "RIVAL": second}
+	start := map[string]int{}
+	for symbol, game := range games {
+		start[symbol] = game.State.Agent.Credits
+	}
+	for step := 0; step < 20000 && (sales(first.Account.Ledger) == 0 || sales(second.Account.Ledger) == 0); step++ {
+		first.Step()
+		second.Step()
+	}
+	for symbol, game := range games {
+		if sales(game.Account.Ledger) == 0 {
+			t.Fatalf("%s did not sell anything", symbol)
+		}
+		if game.State.Agent.Symbol != symbol {
+			t.Errorf("%s plays agent %s", symbol, game.State.Agent.Symbol)
+		}
+		for _, ship := range game.State.Ships {
+			if !strings.HasPrefix(baseShip(ship).Symbol, symbol+"-") {
+				t.Errorf("%s has ship %s", symbol, baseShip(ship).Symbol)
+			}
+		}
+		total := 0
+		for _, entry := range game.Account.Ledger.Entries {
+			if entry.Ship != "" && !strings.HasPrefix(entry.Ship, symbol+"-") {
+				t.Errorf("%s has ledger entry %+v", symbol, entry)
+			}
+			total += entry.Credits
+		}
+		if game.State.Agent.Credits != start[symbol]+total {
+			t.Errorf("%s has %d credits, started with %d and the ledger adds %d", symbol, game.State.Agent.Credits, start[symbol], total)
+		}
+		resp, err := game.Account.Client.GetMyAgentWithResponse(context.TODO())
+		if err != nil || resp.StatusCode() != 200 {
+			t.Fatalf("%s agent not fetched: %v", symbol, err)
+		}
+		if resp.JSON200.Data.Credits != game.State.Agent.Credits {
+			t.Errorf("%s has %d credits on the server, %d in the game", symbol, resp.JSON200.Data.Credits, game.State.Agent.Credits)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+)
+
+// SURVEY_CLAIM_TIMEOUT is how long a claim on surveying a waypoint lasts, so a
+// surveyor that got stuck or was moved elsewhere does not lock the field forever.
+const SURVEY_CLAIM_TIMEOUT = 5 * time.Minute
+
+// SurveyClaim is a ship surveying a waypoint and since when.
+type SurveyClaim struct {
+	Ship  string
+	Since time.Time
+}
+
+// SurveySizeFactor weighs a survey by how long it is likely to last before
+// being exhausted, so larger deposits are preferred over equally rich small ones.
+func SurveySizeFactor(size client.SurveySize) float64 {
+	switch size {
+	case client.SurveySizeLARGE:
+		return 3
+	case client.SurveySizeMODERATE:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// ScoreSurvey returns the expected value of a single extraction from the survey.
+// Every deposit is assumed to be equally likely to be yielded, so the score is the
+// average value of the deposits multiplied by the size factor of the survey.
+func ScoreSurvey(survey client.Survey, values map[string]int) float64 {
+	if len(survey.Deposits) == 0 {
+		return 0
+	}
+	total := 0
+	for _, dep := range survey.Deposits {
+		total += values[dep.Symbol]
+	}
+	return float64(total) / float64(len(survey.Deposits)) * SurveySizeFactor(survey.Size)
+}
+
+// PruneSurveys removes all surveys that have expired.
+func (state *State) PruneSurveys() {
+	for waypoint, surveys := range state.Surveys {
+		valid := surveys[:0]
+		for _, survey := range surveys {
+			if survey.Expiration.After(state.Clock.Now()) {
+				valid = append(valid, survey)
+			}
+		}
+		if len(valid) == 0 {
+			delete(state.Surveys, waypoint)
+		} else {
+			state.Surveys[waypoint] = valid
+		}
+	}
+}
+
+// DiscardSurvey removes a survey that the API reported as exhausted or otherwise
+// unusable, so no miner will target it again.
+func (state *State) DiscardSurvey(survey client.Survey) {
+	surveys := state.Surveys[survey.Symbol]
+	for i, s := range surveys {
+		if s.Signature == survey.Signature {
+			state.Surveys[survey.Symbol] = append(surveys[:i], surveys[i+1:]...)
+			return
+		}
+	}
+}
+
+// IsSurveyUsable reports whether the survey is still known and will not expire
+// within the next second.
+func (state *State) IsSurveyUsable(survey client.Survey) bool {
+	if !survey.Expiration.After(state.Clock.Now().Add(time.Second)) {
+		return false
+	}
+	for _, s := range state.Surveys[survey.Symbol] {
+		if s.Signature == survey.Signature {
+			return true
+		}
+	}
+	return false
+}
+
+// CanClaimSurveying reports whether the ship may survey the waypoint, because no
+// other ship is surveying there.
+func (state *State) CanClaimSurveying(waypointSymbol string, shipSymbol string) bool {
+	claim, ok := state.Surveying[waypointSymbol]
+	return !ok || claim.Ship == shipSymbol || state.Clock.Now().Sub(claim.Since) > SURVEY_CLAIM_TIMEOUT
+}
+
+// ClaimSurveying registers the ship as the surveyor of the waypoint. It returns
+// false if another ship is already surveying there, so miners at the same field
+// share surveys instead of all surveying.
+func (state *State) ClaimSurveying(waypointSymbol string, shipSymbol string) bool {
+	if !state.CanClaimSurveying(waypointSymbol, shipSymbol) {
+		return false
+	}
+	if state.Surveying == nil {
+		state.Surveying = make(map[string]SurveyClaim)
+	}
+	state.Surveying[waypointSymbol] = SurveyClaim{Ship: shipSymbol, Since: state.Clock.Now()}
+	return true
+}
+
+// ReleaseSurveying removes the ship's claim on surveying the waypoint.
+func (state *State) ReleaseSurveying(waypointSymbol string, shipSymbol string) {
+	if state.Surveying[waypointSymbol].Ship == shipSymbol {
+		delete(state.Surveying, waypointSymbol)
+	}
+}
+
+// ReleaseAllSurveying removes the ship's claims on surveying any waypoint.
+func (state *State) ReleaseAllSurveying(shipSymbol string) {
+	for waypointSymbol, claim := range state.Surveying {
+		if claim.Ship == shipSymbol {
+			delete(state.Surveying, waypointSymbol)
+		}
+	}
+}
+
+// GetBestSurvey returns the unexpired survey at the waypoint with the highest score,
+// or nil if no survey contains any of the valued goods.
+func (state *State) GetBestSurvey(waypointSymbol string, values map[string]int) *client.Survey {
+	var best *client.Survey
+	bestScore := 0.0
+	for i, survey := range state.Surveys[waypointSymbol] {
+		if !survey.Expiration.After(state.Clock.Now()) {
+			continue
+		}
+		score := ScoreSurvey(survey, values)
+		if score > bestScore {
+			best = &state.Surveys[waypointSymbol][i]
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// GoodValues returns the value per unit of every good the miner may want to extract.
+// Goods are valued at the best known market price, and goods that are still needed
+// for the contract are valued at least at the contract payment per unit.
+func (ship *Miner) GoodValues(gameState *State) map[string]int {
+	values := make(map[string]int)
+	for _, market := range gameState.Markets {
+		if market.TradeGoods == nil || ship.Account.Strategy.IsBlacklisted(market.Symbol) {
+			continue
+		}
+		for _, tg := range *market.TradeGoods {
+			if tg.SellPrice > values[tg.Symbol] {
+				values[tg.Symbol] = tg.SellPrice
+			}
+		}
+	}
+	if ship.Contract.Terms.Deliver == nil {
+		return values
+	}
+	payment := ship.Contract.Terms.Payment.OnAccepted + ship.Contract.Terms.Payment.OnFulfilled
+	required := 0
+	for _, deliver := range *ship.Contract.Terms.Deliver {
+		required += deliver.UnitsRequired
+	}
+	for _, deliver := range *ship.Contract.Terms.Deliver {
+		if deliver.UnitsFulfilled >= deliver.UnitsRequired {
+			continue
+		}
+		perUnit := 1
+		if required > 0 && payment/required > perUnit {
+			perUnit = payment / required
+		}
+		if perUnit > values[deliver.TradeSymbol] {
+			values[deliver.TradeSymbol] = perUnit
+		}
+	}
+	return values
+}
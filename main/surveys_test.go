@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Dutchy-/spacetrader-go/client"
+	"github.com/Dutchy-/spacetrader-go/main/clock"
+)
+
+func TestSurveyExpiry(t *testing.T) {
+	fake := clock.NewFake(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+	state := State{Clock: fake, Surveys: map[string][]client.Survey{}}
+	short := client.Survey{Signature: "SHORT", Symbol: "X1-A1", Expiration: fake.Now().Add(time.Minute)}
+	long := client.Survey{Signature: "LONG", Symbol: "X1-A1", Expiration: fake.Now().Add(10 * time.Minute)}
+	other := client.Survey{Signature: "OTHER", Symbol: "X1-B1", Expiration: fake.Now().Add(time.Minute)}
+	state.AddSurveys("X1-A1", []client.Survey{short, long})
+	state.AddSurveys("X1-B1", []client.Survey{other})
+
+	for _, survey := range []client.Survey{short, long, other} {
+		if !state.IsSurveyUsable(survey) {
+			t.Errorf("survey %s not usable before it expires", survey.Signature)
+		}
+	}
+	// a survey about to expire is no longer worth an extraction
+	fake.Advance(time.Minute - time.Second)
+	if state.IsSurveyUsable(short) {
+		t.Errorf("survey SHORT usable a second before it expires")
+	}
+	state.PruneSurveys()
+	if len(state.Surveys["X1-A1"]) != 2 {
+		t.Errorf("pruned surveys before they expired: %v", state.Surveys)
+	}
+
+	fake.Advance(time.Second)
+	state.PruneSurveys()
+	if len(state.Surveys["X1-A1"]) != 1 || state.Surveys["X1-A1"][0].Signature != "LONG" {
+		t.Errorf("surveys at X1-A1 after pruning = %v, want only LONG", state.Surveys["X1-A1"])
+	}
+	if _, ok := state.Surveys["X1-B1"]; ok {
+		t.Errorf("X1-B1 still has surveys after all expired")
+	}
+	if !state.IsSurveyUsable(long) {
+		t.Errorf("survey LONG not usable before it expires")
+	}
+	if state.IsSurveyUsable(other) {
+		t.Errorf("survey OTHER usable after it was pruned")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	TUI_INTERVAL = time.Second
+	// TUI_EVENTS is how many log lines the event log keeps
+	TUI_EVENTS = 500
+	// TUI_CREDIT_SAMPLES is how many credit samples the sparkline keeps, one per TUI_INTERVAL
+	TUI_CREDIT_SAMPLES = 300
+	TUI_BAR_WIDTH      = 10
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// TUI draws the fleet and a credits sparkline of every agent and the latest log
+// lines on the terminal, redrawing the screen every TUI_INTERVAL.
+type TUI struct {
+	mu        sync.Mutex
+	out       io.Writer
+	snapshots map[string]Snapshot
+	credits   map[string][]int
+	events    []string
+	partial   []byte
+}
+
+func NewTUI(out io.Writer) *TUI {
+	return &TUI{out: out, snapshots: map[string]Snapshot{}, credits: map[string][]int{}}
+}
+
+// Screen is the terminal UI, if it is enabled.
+var Screen *TUI
+
+// Write adds log lines to the event log, so the logger can write to the TUI
+// instead of messing up the screen.
+func (t *TUI) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.events = append(t.events, string(t.partial[:i]))
+		t.partial = t.partial[i+1:]
+	}
+	if len(t.events) > TUI_EVENTS {
+		t.events = t.events[len(t.events)-TUI_EVENTS:]
+	}
+	return len(p), nil
+}
+
+// Update takes a new snapshot of the agent's game at most every TUI_INTERVAL.
+// It is called from the game loop.
+func (t *TUI) Update(name string, state *State) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state.Clock.Now().Sub(t.snapshots[name].Time) < TUI_INTERVAL {
+		return
+	}
+	t.snapshots[name] = NewSnapshot(name, state)
+	credits := append(t.credits[name], state.Agent.Credits)
+	if len(credits) > TUI_CREDIT_SAMPLES {
+		credits = credits[len(credits)-TUI_CREDIT_SAMPLES:]
+	}
+	t.credits[name] = credits
+}
+
+// Run redraws the screen forever.
+func (t *TUI) Run() {
+	for {
+		width, height := TerminalSize()
+		t.mu.Lock()
+		screen := t.Render(width, height)
+		t.mu.Unlock()
+		fmt.Fprint(t.out, screen)
+		time.Sleep(TUI_INTERVAL)
+	}
+}
+
+// Render returns the escape sequences and text that draw the whole screen.
+func (t *TUI) Render(width, height int) string {
+	lines := []string{}
+	for _, name := range sortedKeys(t.snapshots) {
+		lines = append(lines, t.renderAgent(t.snapshots[name], t.credits[name], width)...)
+	}
+	lines = append(lines, "EVENTS")
+	room := height - len(lines) - 1
+	events := t.events
+	if room < 0 {
+		room = 0
+	}
+	if len(events) > room {
+		events = events[len(events)-room:]
+	}
+	lines = append(lines, events...)
+
+	b := &strings.Builder{}
+	// Move home and overwrite line by line, clearing the rest of each line, to avoid flicker
+	b.WriteString("\x1b[H")
+	for _, line := range lines {
+		b.WriteString(truncate(line, width))
+		b.WriteString("\x1b[K\n")
+	}
+	b.WriteString("\x1b[J")
+	return b.String()
+}
+
+func (t *TUI) renderAgent(snapshot Snapshot, credits []int, width int) []string {
+	lines := []string{
+		fmt.Sprintf("%s (%s)  %d credits  %s", snapshot.Agent, snapshot.Name, snapshot.Credits, snapshot.Time.Format("15:04:05")),
+		"credits " + Sparkline(credits, width-8),
+		"",
+		fmt.Sprintf("%-16s %-20s %-10s %-8s %-*s %-8s %-*s", "SHIP", "STATE", "NAV", "ETA", TUI_BAR_WIDTH+8, "CARGO", "FUEL", TUI_BAR_WIDTH+5, "COOLDOWN"),
+	}
+	for _, ship := range snapshot.Ships {
+		eta := ""
+		if ship.Arrival > 0 {
+			eta = ship.Arrival.String()
+		}
+		cooldown := ""
+		if ship.Cooldown > 0 {
+			cooldown = Bar(int(ship.Cooldown.Seconds()), int(ship.CooldownTotal.Seconds()), TUI_BAR_WIDTH) + fmt.Sprintf(" %3ds", int(ship.Cooldown.Seconds()))
+		}
+		lines = append(lines, fmt.Sprintf("%-16s %-20s %-10s %-8s %s %3d/%-3d %-8s %s",
+			ship.Symbol, ship.State, ship.NavStatus, eta,
+			Bar(ship.CargoUnits, ship.CargoCapacity, TUI_BAR_WIDTH), ship.CargoUnits, ship.CargoCapacity,
+			fmt.Sprintf("%d/%d", ship.Fuel, ship.FuelCapacity), cooldown))
+	}
+	return append(lines, "")
+}
+
+// Bar draws value out of total as a bar of the given width.
+func Bar(value, total, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = width * value / total
+	}
+	if filled > width {
+		filled = width
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+// Sparkline draws the last width values scaled between their minimum and maximum.
+func Sparkline(values []int, width int) string {
+	if width < 1 || len(values) == 0 {
+		return ""
+	}
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	line := make([]rune, len(values))
+	for i, v := range values {
+		level := 0
+		if max > min {
+			level = (v - min) * (len(sparks) - 1) / (max - min)
+		}
+		line[i] = sparks[level]
+	}
+	return string(line)
+}
+
+func truncate(line string, width int) string {
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	return string([]rune(line)[:width])
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// TerminalSize returns the default terminal size, as it cannot be queried here.
+func TerminalSize() (int, int) {
+	return 80, 24
+}
@@ -0,0 +1,22 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// TerminalSize returns the width and height of the terminal on stdout, or 80x24
+// if stdout is not a terminal.
+func TerminalSize() (int, int) {
+	var size struct {
+		Rows, Cols, X, Y uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdout.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&size)))
+	if errno != 0 || size.Cols == 0 || size.Rows == 0 {
+		return 80, 24
+	}
+	return int(size.Cols), int(size.Rows)
+}